@@ -0,0 +1,41 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// values with spaces can't be split, since there's no shell involved
+func TestArgvSubstitution(t *testing.T) {
+	r := run(t, `{"city":"New York","n":2}`, "--argv", "--", "printf", `[%s] [%s]\n`, "{city}", "n={n}")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "[New York] [n=2]\n", r.stdout)
+}
+
+// shell syntax in values and in the command is passed through literally
+func TestArgvNoShell(t *testing.T) {
+	r := run(t, `{"v":"$(echo injected); echo"}`, "--argv", "--", "echo", "{v}", "$HOME")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "$(echo injected); echo $HOME\n", r.stdout)
+}
+
+// a record missing a placeholder's field fails, like a failing command
+func TestArgvMissingField(t *testing.T) {
+	r := run(t, `{"a":1}`, "--argv", "--", "echo", "{b}")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, `no field \"b\" in record`)
+}
+
+func TestArgvInvalidTemplate(t *testing.T) {
+	r := run(t, `{"a":1}`, "--argv", "--", "echo", "{a")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "invalid --argv command")
+}
+
+func TestArgvAndExecConflict(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo", "--argv", "--", "echo")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "can't be used together")
+}
@@ -0,0 +1,75 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	streamexec "github.com/davidporter-id-au/stream-exec/stream-exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// concurrency climbs while commands succeed, and decisions are reported
+func TestAutoConcurrency(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&sb, `{"i":%d}`+"\n", i)
+	}
+	cmd, wait := startBackground(t, sb.String(),
+		"run", "--exec", "sleep 0.05", "--concurrency", "auto", "--max-concurrency", "4",
+		"--auto-concurrency-interval", "150ms")
+	defer func() {
+		cmd.Process.Kill()
+		wait()
+	}()
+	waitForSocket(t, cmd.Process.Pid)
+
+	sock := streamexec.SocketPath(cmd.Process.Pid)
+	var st *streamexec.StatusResponse
+	require.Eventually(t, func() bool {
+		resp, err := streamexec.QuerySocket(sock, "status")
+		if err != nil || resp.Status == nil {
+			return false
+		}
+		st = resp.Status
+		return st.AutoConcurrency != nil && st.AutoConcurrency.Target == 4 && st.Concurrency == 4
+	}, 5*time.Second, 20*time.Millisecond)
+
+	assert.Equal(t, 1, st.AutoConcurrency.Min)
+	assert.Equal(t, 4, st.AutoConcurrency.Max)
+	require.NotEmpty(t, st.AutoConcurrency.Decisions)
+	assert.Contains(t, st.AutoConcurrency.Decisions[len(st.AutoConcurrency.Decisions)-1].Reason, "healthy")
+}
+
+// failures bring it back down to the minimum
+func TestAutoConcurrencyBacksOff(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&sb, `{"i":%d}`+"\n", i)
+	}
+	r := run(t, sb.String(), "--exec", `sleep 0.02; [ $i -lt 50 ]`, "--continue", "--debug",
+		"--concurrency", "auto", "--min-concurrency", "2", "--max-concurrency", "6",
+		"--auto-concurrency-interval", "100ms")
+	assert.Equal(t, 0, r.exitCode)
+	assert.Regexp(t, `concurrency \d+ -> \d+: failure rate \d+% is over 5%`, r.stdout)
+	assert.Contains(t, r.stdout, "concurrency 2 -> 2: failure rate")
+}
+
+func TestConcurrencyInvalid(t *testing.T) {
+	tests := map[string]struct {
+		args     []string
+		expected string
+	}{
+		"not a number": {args: []string{"--concurrency", "lots"}, expected: `invalid concurrency "lots"`},
+		"bad range":    {args: []string{"--concurrency", "auto", "--min-concurrency", "5", "--max-concurrency", "2"}, expected: "invalid concurrency range 5-2"},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := run(t, `{"a":1}`, append([]string{"--exec", "echo $a"}, td.args...)...)
+			assert.NotEqual(t, 0, r.exitCode)
+			assert.Contains(t, r.stderr, td.expected)
+		})
+	}
+}
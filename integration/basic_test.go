@@ -93,3 +93,51 @@ func TestSuccessOutputOnStdout(t *testing.T) {
 	assert.Contains(t, r.stdout, "hello")
 	assert.Empty(t, r.stderr)
 }
+
+// with --flatten nested values are also available individually
+func TestFlattenNestedValues(t *testing.T) {
+	r := run(t, `{"user":{"id":1,"org":"x"},"tags":["a","b"]}`,
+		"--exec", `echo "$user_id $user_org $tags_0 $tags_1 $tags_len"`, "--flatten")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "1 x a b 2")
+}
+
+func TestFlattenSeparatorAndDepth(t *testing.T) {
+	r := run(t, `{"a":{"b":{"c":1}}}`,
+		"--exec", `echo "[$a__b] [$a__b__c]"`, "--flatten", "--flatten-separator", "__", "--flatten-max-depth", "1")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, `[{"c":1}] []`)
+}
+
+// a key of the record's own wins over a flattened value of the same name
+func TestFlattenCollision(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 20; i++ {
+		input.WriteString(`{"user":{"id":1},"user_id":2}` + "\n")
+	}
+	r := run(t, input.String(), "--exec", `echo "id=$user_id"`, "--flatten")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, 20, strings.Count(r.stdout, "id=2"), r.stdout)
+}
+
+// the raw record and its position are available alongside its values
+func TestMetadataVariables(t *testing.T) {
+	input := `{"a":1}` + "\n" + `{"a": 2}` + "\n"
+	r := run(t, input, "--exec", `echo "$SE_INDEX $SE_ATTEMPT $SE_WORKER $SE_RUN_ID $SE_RECORD"`,
+		"--concurrency", "1", "--run-id", "run-1")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "0 1 0 run-1 {\"a\":1}\n1 1 0 run-1 {\"a\": 2}\n", r.stdout)
+}
+
+func TestMetadataPrefix(t *testing.T) {
+	r := run(t, `{"SE_INDEX":"mine"}`, "--exec", `echo "$SE_INDEX $META_INDEX"`, "--meta-prefix", "META_")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "mine 0")
+}
+
+// SE_ATTEMPT counts up with each retry
+func TestMetadataAttempt(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", `echo "attempt $SE_ATTEMPT"; [ "$SE_ATTEMPT" = 2 ]`, "--retries", "2")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "attempt 2")
+}
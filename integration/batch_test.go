@@ -0,0 +1,126 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchStdin(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, seqInput(5), "--exec", `echo "$SE_BATCH_SIZE $(cat)"`,
+		"--batch-size", "2", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	lines := strings.Split(strings.TrimSpace(r.stdout), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{
+		`1 [{"seq":4}]`,
+		`2 [{"seq":0},{"seq":1}]`,
+		`2 [{"seq":2},{"seq":3}]`,
+	}, lines)
+
+	results := readOutputLog(t, logPath)
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	require.Len(t, results, 3)
+	assert.Equal(t, []int64{0, 1}, results[0].Batch)
+	assert.Equal(t, []int64{2, 3}, results[1].Batch)
+	assert.Equal(t, []int64{4}, results[2].Batch)
+}
+
+// a batch bigger than the environment allows still reaches the command, as
+// none of it goes in the environment
+func TestBatchTooBigForEnv(t *testing.T) {
+	var input strings.Builder
+	payload := strings.Repeat("x", 1000)
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&input, `{"seq":%d,"payload":"%s"}`+"\n", i, payload)
+	}
+	for _, mode := range []string{"stdin", "file"} {
+		t.Run(mode, func(t *testing.T) {
+			r := run(t, input.String(), "--exec", `[ -z "$SE_RECORD" ] || exit 1; echo "$SE_BATCH_SIZE"`,
+				"--batch-size", "200", "--batch-input", mode)
+			require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+			lines := strings.Fields(r.stdout)
+			sort.Strings(lines)
+			assert.Equal(t, []string{"100", "200"}, lines)
+		})
+	}
+}
+
+func TestBatchFile(t *testing.T) {
+	r := run(t, seqInput(2), "--exec", `cat "$SE_BATCH_FILE"`, "--batch-size", "2", "--batch-input", "file")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, `[{"seq":0},{"seq":1}]`+"\n", r.stdout)
+}
+
+func TestBatchEnv(t *testing.T) {
+	r := run(t, seqInput(3), "--exec", `echo "$seq_0 $seq_1 $seq_2"`, "--batch-size", "3", "--batch-input", "env")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "0 1 2\n", r.stdout)
+}
+
+func TestBatchLines(t *testing.T) {
+	r := run(t, "a\nb\n", "--exec", "cat", "--batch-size", "2", "--input-format", "lines")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, `[{"line":"a"},{"line":"b"}]`+"\n", r.stdout)
+}
+
+// lines that happen to be valid JSON are still lines
+func TestBatchLinesLookingLikeJSON(t *testing.T) {
+	r := run(t, "12345\nabc\n\"q\"\n", "--exec", "cat", "--batch-size", "3", "--input-format", "lines", "--line-var", "id")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, `[{"id":"12345"},{"id":"abc"},{"id":"\"q\""}]`+"\n", r.stdout)
+}
+
+// a partial batch runs once the timeout passes, without waiting for more input
+func TestBatchTimeout(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	cmd := exec.Command(binaryPath, "run", "--exec", `echo "$SE_BATCH_SIZE" >> `+out,
+		"--batch-size", "10", "--batch-timeout", "100ms")
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	_, err = stdin.Write([]byte(`{"seq":0}` + "\n"))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		b, _ := os.ReadFile(out)
+		return string(b) == "1\n"
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestBatchFailureListsRecords(t *testing.T) {
+	r := run(t, seqInput(2), "--exec", "exit 1", "--batch-size", "2")
+	assert.Equal(t, 1, r.exitCode)
+	assert.Contains(t, r.stderr, `"Batch":[0,1]`)
+}
+
+func TestBatchStdinConflict(t *testing.T) {
+	r := run(t, seqInput(2), "--exec", "cat", "--batch-size", "2", "--stdin", "record")
+	assert.Equal(t, 1, r.exitCode)
+	assert.Contains(t, r.stderr, "--stdin can't be used with --batch-size")
+}
+
+// --argv's placeholders have no record to take values from
+func TestBatchArgvConflict(t *testing.T) {
+	r := run(t, seqInput(2), "--argv", "--batch-size", "2", "--", "echo", "{seq}")
+	assert.Equal(t, 1, r.exitCode)
+	assert.Contains(t, r.stderr, "--argv and --batch-size can't be used together")
+}
+
+func TestBatchInputInvalid(t *testing.T) {
+	r := run(t, seqInput(2), "--exec", "cat", "--batch-size", "2", "--batch-input", "pipe")
+	assert.Equal(t, 1, r.exitCode)
+	assert.Contains(t, r.stderr, `unknown batch input "pipe"`)
+}
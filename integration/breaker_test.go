@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	streamexec "github.com/davidporter-id-au/stream-exec/stream-exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func breakerInput(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `{"i":%d}`+"\n", i)
+	}
+	return sb.String()
+}
+
+// once tripped, records aren't burnt through while the downstream is down
+func TestBreakerPausesAfterFailures(t *testing.T) {
+	start := time.Now()
+	r := run(t, breakerInput(6), "--exec", "exit 1", "--continue", "--concurrency", "1",
+		"--breaker-failures", "3", "--breaker-cooldown", "400ms")
+	elapsed := time.Since(start)
+	assert.Equal(t, 0, r.exitCode)
+	// 3 failures trip it; each of the remaining 3 records is a failed probe
+	// run after a cooldown
+	assert.GreaterOrEqual(t, elapsed, 1200*time.Millisecond)
+	assert.Equal(t, 4, strings.Count(r.stderr, "circuit breaker open"), r.stderr)
+}
+
+// a successful probe closes the breaker again
+func TestBreakerRecovers(t *testing.T) {
+	r := run(t, breakerInput(6), "--exec", `[ $i -ge 3 ] || exit 1; echo ok $i`, "--continue", "--concurrency", "1",
+		"--breaker-failures", "3", "--breaker-cooldown", "100ms")
+	assert.Equal(t, 0, r.exitCode)
+	assert.Equal(t, 1, strings.Count(r.stderr, "circuit breaker open"), r.stderr)
+	assert.Contains(t, r.stderr, "circuit breaker closed")
+	for _, i := range []int{3, 4, 5} {
+		assert.Contains(t, r.stdout, fmt.Sprintf("ok %d", i))
+	}
+}
+
+func TestBreakerStatus(t *testing.T) {
+	cmd, wait := startBackground(t, breakerInput(5),
+		"run", "--exec", "exit 1", "--continue", "--concurrency", "1",
+		"--breaker-failures", "1", "--breaker-cooldown", "10s")
+	defer func() {
+		cmd.Process.Kill()
+		wait()
+	}()
+	waitForSocket(t, cmd.Process.Pid)
+
+	sock := streamexec.SocketPath(cmd.Process.Pid)
+	require.Eventually(t, func() bool {
+		resp, err := streamexec.QuerySocket(sock, "status")
+		return err == nil && resp.Status.Breaker != nil && resp.Status.Breaker.State == streamexec.BreakerOpen
+	}, 3*time.Second, 20*time.Millisecond)
+
+	resp, err := streamexec.QuerySocket(sock, "status")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.Status.Breaker.Trips)
+	assert.WithinDuration(t, time.Now().Add(10*time.Second), resp.Status.Breaker.OpenUntil, 2*time.Second)
+
+	listOut, err := exec.Command(binaryPath, "list").CombinedOutput()
+	require.NoError(t, err)
+	assert.Contains(t, string(listOut), "BREAKER")
+	assert.Regexp(t, fmt.Sprintf(`%d .* open \(\d+s\)`, cmd.Process.Pid), string(listOut))
+}
+
+func TestBreakerFailureRatioInvalid(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--breaker-failure-ratio", "2")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "invalid --breaker-failure-ratio 2")
+}
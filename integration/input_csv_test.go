@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// CSV rows are exposed using the header row as variable names.
+func TestCSVInputHeaderVariables(t *testing.T) {
+	input := "user,document\nalice,doc1\nbob,doc2\n"
+	r := run(t, input, "--exec", "echo $user $document", "--input-format", "csv")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "alice doc1")
+	assert.Contains(t, r.stdout, "bob doc2")
+}
+
+// header names are normalised the same way as JSON keys.
+func TestCSVInputHeaderNormalised(t *testing.T) {
+	input := "user-id,first name\n42,New York\n"
+	r := run(t, input, "--exec", `echo "$user_id/$first_name"`, "--input-format", "csv")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "42/New York")
+}
+
+// quoted fields may contain the delimiter and escaped quotes.
+func TestCSVInputQuotedFields(t *testing.T) {
+	input := "name,note\n\"Smith, John\",\"said \"\"hi\"\"\"\n"
+	r := run(t, input, "--exec", `echo "$name|$note"`, "--input-format", "csv")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, `Smith, John|said "hi"`)
+}
+
+func TestTSVInput(t *testing.T) {
+	input := "city\tcountry\nNew York\tUS\n"
+	r := run(t, input, "--exec", `echo "$city,$country"`, "--input-format", "tsv")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "New York,US")
+}
+
+func TestCSVInputCustomDelimiter(t *testing.T) {
+	input := "a;b\n1;2\n"
+	r := run(t, input, "--exec", "echo $a-$b", "--input-format", "csv", "--delimiter", ";")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "1-2")
+}
+
+// --no-header exposes columns positionally, including the first row.
+func TestCSVInputNoHeader(t *testing.T) {
+	input := "alice,1\nbob,2\n"
+	r := run(t, input, "--exec", "echo $c1:$c2", "--input-format", "csv", "--no-header")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "alice:1")
+	assert.Contains(t, r.stdout, "bob:2")
+}
+
+// like a bad JSON line, a malformed row is reported and the rest still run
+func TestCSVInputBadRow(t *testing.T) {
+	input := "a,b\n1,x\"y\n2,3\n4,5\n"
+	r := run(t, input, "--exec", "echo $a", "--input-format", "csv", "--continue")
+	assert.Contains(t, r.stderr, "parse error on line 2")
+	assert.Contains(t, r.stdout, "2")
+	assert.Contains(t, r.stdout, "4")
+}
+
+func TestCSVInputFile(t *testing.T) {
+	path := writeTemp(t, "name\ncarol\ndave\n")
+	r := run(t, "", "--exec", "echo $name", "--input-format", "csv", "--input-json-file", path)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "carol")
+	assert.Contains(t, r.stdout, "dave")
+}
+
+func TestUnknownInputFormat(t *testing.T) {
+	r := run(t, "", "--exec", "echo hi", "--input-format", "xml")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "unknown input format")
+}
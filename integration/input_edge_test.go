@@ -10,7 +10,7 @@ import (
 )
 
 // TC-21: a single trailing newline (the common case from echo/editors) is handled correctly.
-// splitInputBytes absorbs the trailing \n into nextRemainder which becomes "".
+// The trailing \n leaves an empty final line, which the JSON lines source skips.
 func TestSingleTrailingNewlineIsHandledCorrectly(t *testing.T) {
 	input := `{"a":1}` + "\n"
 	r := run(t, input, "--exec", "echo $a")
@@ -35,12 +35,8 @@ func TestBlankLinesBetweenRecordsCauseErrors(t *testing.T) {
 	assert.Contains(t, r.stdout, "2")
 }
 
-// TC-23: line longer than the 5000-byte read buffer.
-//
-// BUG in splitInputBytes (stream-exec.go:258-260): when the current read chunk has no
-// newline, it returns (nil, dataString) — discarding prevRemainder entirely. So for a
-// line spanning N reads, only the last chunk survives; earlier chunks are silently lost.
-// The truncated JSON is then rejected by the parser.
+// TC-23: line longer than the 5000-byte read buffer must be reassembled in full
+// rather than split across reads.
 func TestLineLongerThanReadBuffer(t *testing.T) {
 	longValue := strings.Repeat("x", 8000)
 	input := fmt.Sprintf(`{"val":"%s"}`, longValue)
@@ -111,19 +107,19 @@ func TestShellMetacharactersInValues(t *testing.T) {
 }
 
 // TC-29: very large integer near float64 precision boundary
-// JSON numbers are float64; 2^53+1 cannot be represented exactly.
-// This test documents the known precision loss rather than asserting correct behaviour.
-func TestLargeIntegerPrecisionLoss(t *testing.T) {
-	// 2^53 = 9007199254740992 is the last exactly-representable integer in float64.
-	// 2^53 + 1 = 9007199254740993 is NOT exactly representable.
+// 2^53 + 1 = 9007199254740993 is NOT exactly representable as a float64, so
+// numbers are decoded as written rather than through float64.
+func TestLargeIntegerPrecision(t *testing.T) {
 	r := run(t, `{"id":9007199254740993}`, "--exec", "echo $id")
 	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "9007199254740993", strings.TrimSpace(r.stdout))
+}
 
-	output := strings.TrimSpace(r.stdout)
-	if output != "9007199254740993" {
-		t.Logf("KNOWN LIMITATION (TC-29): float64 precision loss: input 9007199254740993, got %s", output)
-		// Not a hard failure — document the limitation
-	}
+// --exact-numbers passes numbers through verbatim, exponents and trailing zeros included
+func TestExactNumbers(t *testing.T) {
+	r := run(t, `{"a":1e3,"b":1.50}`, "--exec", "echo $a $b", "--exact-numbers")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "1e3 1.50", strings.TrimSpace(r.stdout))
 }
 
 // keys with leading digits are not valid POSIX env var names; the tool replaces
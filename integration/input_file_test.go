@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"compress/gzip"
 	"os"
 	"path/filepath"
 	"testing"
@@ -54,3 +55,69 @@ func TestInputFileMissing(t *testing.T) {
 	assert.NotEqual(t, 0, r.exitCode)
 	assert.Contains(t, r.stderr, "cannot open input file")
 }
+
+func writeTempGzip(t *testing.T, content string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stream-exec-input-*.json.gz")
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+	return f.Name()
+}
+
+// compressed JSON lines are decompressed transparently.
+func TestInputFileGzipJSONLines(t *testing.T) {
+	path := writeTempGzip(t, `{"name":"alice"}`+"\n"+`{"name":"bob"}`+"\n")
+	r := run(t, "", "--exec", "echo $name", "--input-json-file", path)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "alice")
+	assert.Contains(t, r.stdout, "bob")
+}
+
+// JSON array detection still applies after decompression.
+func TestInputFileGzipJSONArray(t *testing.T) {
+	path := writeTempGzip(t, `[{"name":"carol"},{"name":"dave"}]`)
+	r := run(t, "", "--exec", "echo $name", "--input-json-file", path)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "carol")
+	assert.Contains(t, r.stdout, "dave")
+}
+
+// repeated --input-json-file flags are read one after the other.
+func TestInputFileRepeated(t *testing.T) {
+	first := writeTemp(t, `{"name":"alice"}`+"\n")
+	second := writeTemp(t, `[{"name":"bob"}]`)
+	r := run(t, "", "--exec", "echo $name", "--input-json-file", first, "--input-json-file", second)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "alice\nbob\n", r.stdout)
+}
+
+// a glob pattern expands to every matching file, and each record knows
+// which file and line it came from.
+func TestInputFileGlob(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.jsonl"), []byte(`{"n":"1"}`+"\n"+`{"n":"2"}`+"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.jsonl"), []byte(`{"n":"3"}`+"\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte(`{"n":"4"}`+"\n"), 0644))
+
+	logPath := filepath.Join(dir, "out.log")
+	r := run(t, "", "--exec", `echo "$n $(basename $SE_SOURCE_FILE):$SE_LINE"`,
+		"--input-json-file", filepath.Join(dir, "*.jsonl"),
+		"--output-log-path", logPath,
+	)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "1 a.jsonl:1\n2 a.jsonl:2\n3 b.jsonl:1\n", r.stdout)
+
+	log, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(log), `"SourceFile":"`+filepath.Join(dir, "b.jsonl")+`","Line":1`)
+}
+
+func TestInputFileGlobNoMatches(t *testing.T) {
+	r := run(t, "", "--exec", "echo $x", "--input-json-file", filepath.Join(t.TempDir(), "*.jsonl"))
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "cannot open input file")
+}
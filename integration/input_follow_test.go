@@ -0,0 +1,88 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// --follow keeps consuming lines appended to the input file until stopped.
+func TestFollowInputFile(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.jsonl")
+	output := filepath.Join(dir, "output.txt")
+	require.NoError(t, os.WriteFile(input, []byte(`{"n":"first"}`+"\n"), 0644))
+
+	out, err := os.Create(output)
+	require.NoError(t, err)
+	defer out.Close()
+
+	cmd := exec.Command(binaryPath, "run", "--exec", "echo $n", "--input-json-file", input, "--follow")
+	cmd.Stdout = out
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+	waitForSocket(t, cmd.Process.Pid)
+
+	outputContains := func(s string) func() bool {
+		return func() bool {
+			b, _ := os.ReadFile(output)
+			return strings.Contains(string(b), s)
+		}
+	}
+	require.Eventually(t, outputContains("first"), 3*time.Second, 20*time.Millisecond)
+
+	f, err := os.OpenFile(input, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"n":"second"}` + "\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.Eventually(t, outputContains("second"), 3*time.Second, 20*time.Millisecond)
+
+	stopOut, err := exec.Command(binaryPath, "signal", "stop", fmt.Sprintf("%d", cmd.Process.Pid)).CombinedOutput()
+	require.NoError(t, err, "stop command failed: %s", stopOut)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit after stop signal within timeout")
+	}
+}
+
+func TestFollowRequiresInputFile(t *testing.T) {
+	r := run(t, "", "--exec", "echo hi", "--follow")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "--follow requires --input-json-file")
+}
+
+// stopping must work while nothing has been written to the file yet
+func TestFollowEmptyInputFileStops(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.jsonl")
+	require.NoError(t, os.WriteFile(input, nil, 0644))
+
+	cmd := exec.Command(binaryPath, "run", "--exec", "echo $n", "--input-json-file", input, "--follow")
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+	waitForSocket(t, cmd.Process.Pid)
+
+	stopOut, err := exec.Command(binaryPath, "signal", "stop", fmt.Sprintf("%d", cmd.Process.Pid)).CombinedOutput()
+	require.NoError(t, err, "stop command failed: %s", stopOut)
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit after stop signal within timeout")
+	}
+}
@@ -0,0 +1,28 @@
+package integration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// each line of plain text input is exposed as a single variable.
+func TestLinesInput(t *testing.T) {
+	r := run(t, "id-1\nid 2\n", "--exec", `echo "<$id>"`, "--input-format", "lines", "--line-var", "id", "--concurrency", "1")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "<id-1>\n<id 2>\n", r.stdout)
+}
+
+func TestLinesInputDefaultVar(t *testing.T) {
+	r := run(t, "https://example.com/a?b=c\n", "--exec", `echo "$line"`, "--input-format", "lines")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "https://example.com/a?b=c")
+}
+
+// -0 splits on NUL so file names containing newlines survive, as with find -print0.
+func TestLinesInputNullDelimited(t *testing.T) {
+	r := run(t, "a\nb\x00c\x00", "--exec", `echo "[$line]"`, "--input-format", "lines", "-0", "--concurrency", "1")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "[a\nb]\n[c]\n", r.stdout)
+}
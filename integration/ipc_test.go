@@ -3,6 +3,7 @@ package integration
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -37,13 +38,16 @@ func startBackground(t *testing.T, stdin string, args ...string) (*exec.Cmd, fun
 	return cmd, wait
 }
 
-// waitForSocket polls until the socket file for pid exists, or times out.
+// waitForSocket polls until the socket for pid accepts connections, or times out.
+// Checking the file alone isn't enough: a stale socket left by a killed process
+// with a recycled pid may exist until the new process replaces it.
 func waitForSocket(t *testing.T, pid int) {
 	t.Helper()
 	path := streamexec.SocketPath(pid)
 	deadline := time.Now().Add(3 * time.Second)
 	for time.Now().Before(deadline) {
-		if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
 			return
 		}
 		time.Sleep(20 * time.Millisecond)
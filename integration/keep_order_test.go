@@ -0,0 +1,77 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func seqInput(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, `{"seq":%d}`+"\n", i)
+	}
+	return sb.String()
+}
+
+func TestKeepOrder(t *testing.T) {
+	const count = 50
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	// later records finish first
+	r := run(t, seqInput(count), "--exec", `sleep 0.0$(( (50 - seq) % 10 )); echo $seq`,
+		"--concurrency", "10", "--keep-order", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	var expected strings.Builder
+	for i := 0; i < count; i++ {
+		fmt.Fprintf(&expected, "%d\n", i)
+	}
+	assert.Equal(t, expected.String(), r.stdout)
+
+	log, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	for i, line := range strings.Split(strings.TrimSpace(string(log)), "\n") {
+		assert.Contains(t, line, fmt.Sprintf(`"Index":%d,`, i))
+	}
+}
+
+// a slow record holds the others back once they're a window ahead of it
+func TestKeepOrderWindow(t *testing.T) {
+	starts := filepath.Join(t.TempDir(), "starts")
+	r := run(t, seqInput(10), "--exec", `echo "$seq $(date +%s%3N)" >> `+starts+`; [ $seq = 0 ] && sleep 1; echo $seq`,
+		"--concurrency", "10", "--keep-order", "--reorder-window", "3")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.True(t, strings.HasPrefix(r.stdout, "0\n1\n2\n3\n"), r.stdout)
+
+	b, err := os.ReadFile(starts)
+	require.NoError(t, err)
+	startedAt := map[int]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var seq int
+		var ms int64
+		_, err := fmt.Sscanf(line, "%d %d", &seq, &ms)
+		require.NoError(t, err)
+		startedAt[seq] = ms
+	}
+	require.Len(t, startedAt, 10)
+	for seq := 1; seq < 10; seq++ {
+		delay := time.Duration(startedAt[seq]-startedAt[0]) * time.Millisecond
+		if seq < 3 {
+			assert.Less(t, delay, 500*time.Millisecond, "record %d is within the window", seq)
+		} else {
+			assert.GreaterOrEqual(t, delay, 900*time.Millisecond, "record %d is outside the window", seq)
+		}
+	}
+}
+
+func TestKeepOrderWithStreamOutput(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--keep-order", "--stream-output")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "can't be used together")
+}
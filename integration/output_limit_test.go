@@ -0,0 +1,79 @@
+package integration
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type loggedResult struct {
+	Stdout          string
+	Stderr          string
+	StdoutTruncated int64
+	StderrTruncated int64
+	StdoutFile      string
+	StderrFile      string
+	Succeeded       bool
+	Index           int64
+	Batch           []int64
+}
+
+func readOutputLog(t *testing.T, path string) []loggedResult {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var out []loggedResult
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var r loggedResult
+		require.NoError(t, json.Unmarshal([]byte(line), &r), line)
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, `{"a":1}`, "--exec", "head -c 100000 /dev/zero | tr '\\0' x; echo e >&2",
+		"--max-output-bytes", "10", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "xxxxxxxxxx")
+	assert.NotContains(t, r.stdout, "xxxxxxxxxxx")
+	assert.Contains(t, r.stdout, "[output truncated: 99990 bytes of stdout and 0 bytes of stderr not shown]")
+
+	results := readOutputLog(t, logPath)
+	require.Len(t, results, 1)
+	assert.Equal(t, "xxxxxxxxxx", results[0].Stdout)
+	assert.Equal(t, int64(99990), results[0].StdoutTruncated)
+	assert.Equal(t, "e\n", results[0].Stderr)
+	assert.Equal(t, int64(0), results[0].StderrTruncated)
+}
+
+func TestOutputDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outputs")
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, "{\"a\":1}\n{\"a\":2}\n", "--exec", `seq 50000; [ $a = 1 ] || echo "err $a" >&2`,
+		"--output-dir", dir, "--run-id", "run1", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	results := readOutputLog(t, logPath)
+	require.Len(t, results, 2)
+	for _, res := range results {
+		assert.Empty(t, res.Stdout, "spilled output isn't logged")
+		assert.Zero(t, res.StdoutTruncated)
+		require.NotEmpty(t, res.StdoutFile)
+		b, err := os.ReadFile(res.StdoutFile)
+		require.NoError(t, err)
+		assert.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 50000)
+	}
+
+	assert.FileExists(t, filepath.Join(dir, "run1-0.stdout"))
+	assert.NoFileExists(t, filepath.Join(dir, "run1-0.stderr"), "no file for empty output")
+	b, err := os.ReadFile(filepath.Join(dir, "run1-1.stderr"))
+	require.NoError(t, err)
+	assert.Equal(t, "err 2\n", string(b))
+}
@@ -0,0 +1,45 @@
+package integration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stdout and stderr are kept apart in the output log
+func TestOutputLogSeparatesStreams(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, `{"a":1}`, "--exec", "echo out; echo err >&2", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	log, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(log), `"Stderr":"err\n","Stdout":"out\n"`)
+}
+
+// the stderr of a failed command is reported, not lost
+func TestFailureStderrCaptured(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo progress; echo 'no such thing' >&2; exit 3")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, `"Stderr":"no such thing\n","Stdout":"progress\n","ExitCode":3`)
+}
+
+func TestTextOutputInterleaved(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo one; sleep 0.05; echo two >&2; sleep 0.05; echo three")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "one\ntwo\nthree\n", r.stdout)
+}
+
+func TestTextOutputStdout(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo one; echo two >&2; echo three", "--text-output", "stdout")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "one\nthree\n", r.stdout)
+}
+
+func TestTextOutputInvalid(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--text-output", "both")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, `unknown text output "both"`)
+}
@@ -0,0 +1,111 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// starts reads the "<key> <unix ms>" lines commands append to path
+func starts(t *testing.T, path string) map[string][]int64 {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	out := map[string][]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var key string
+		var ms int64
+		_, err := fmt.Sscanf(line, "%s %d", &key, &ms)
+		require.NoError(t, err)
+		out[key] = append(out[key], ms)
+	}
+	for _, times := range out {
+		sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	}
+	return out
+}
+
+func TestRPSBy(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 6; i++ {
+		fmt.Fprintf(&input, `{"url":"https://host%d.example.com/item/%d"}`+"\n", i%2, i)
+	}
+	path := filepath.Join(t.TempDir(), "starts")
+	r := run(t, input.String(), "--exec", `echo "${url%%/item/*} $(date +%s%3N)" >> `+path,
+		"--concurrency", "6", "--rps-by", "url:host=5")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	byHost := starts(t, path)
+	require.Len(t, byHost, 2)
+	var firsts []int64
+	for host, times := range byHost {
+		require.Len(t, times, 3, host)
+		for i := 1; i < len(times); i++ {
+			assert.GreaterOrEqualf(t, times[i]-times[i-1], int64(150), "%s ran faster than its limit", host)
+		}
+		firsts = append(firsts, times[0])
+	}
+	// one host's limit doesn't hold up the other
+	assert.InDelta(t, firsts[0], firsts[1], 100)
+}
+
+func TestRPSByInvalid(t *testing.T) {
+	tests := map[string]string{
+		"tenant":      `invalid --rps-by "tenant", expected <field>=<rate>`,
+		"=5":          `invalid --rps-by "=5", expected <field>=<rate>`,
+		"tenant=0":    `invalid --rps-by rate "0", expected a number above 0`,
+		"tenant=fast": `invalid --rps-by rate "fast", expected a number above 0`,
+	}
+	for value, expected := range tests {
+		t.Run(value, func(t *testing.T) {
+			r := run(t, `{"tenant":"a"}`, "--exec", "true", "--rps-by", value)
+			assert.Equal(t, 1, r.exitCode)
+			assert.Contains(t, r.stderr, expected)
+		})
+	}
+}
+
+// records for a busy value are held back without taking up the workers,
+// so those for other values don't queue behind them
+func TestRPSByHotKey(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 10; i++ {
+		input.WriteString(`{"tenant":"hot"}` + "\n")
+	}
+	input.WriteString(`{"tenant":"cold"}` + "\n")
+	path := filepath.Join(t.TempDir(), "starts")
+	r := run(t, input.String(), "--exec", `echo "$tenant $(date +%s%3N)" >> `+path,
+		"--concurrency", "2", "--rps-by", "tenant=2")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	byTenant := starts(t, path)
+	require.Len(t, byTenant["hot"], 10)
+	require.Len(t, byTenant["cold"], 1)
+	assert.Lessf(t, byTenant["cold"][0]-byTenant["hot"][0], int64(1000), "cold waited behind hot")
+	hot := byTenant["hot"]
+	for i := 1; i < len(hot); i++ {
+		assert.GreaterOrEqualf(t, hot[i]-hot[i-1], int64(400), "hot ran faster than its limit")
+	}
+}
+
+// records that come due while every worker is busy still run at their
+// value's rate once one's free, rather than all at once
+func TestRPSBySaturatedWorkers(t *testing.T) {
+	input := `{"tenant":"slow","sleep":1}` + "\n" + strings.Repeat(`{"tenant":"a"}`+"\n", 4)
+	path := filepath.Join(t.TempDir(), "starts")
+	r := run(t, input, "--exec", `echo "$tenant $(date +%s%3N)" >> `+path+`; sleep ${sleep:-0}`,
+		"--concurrency", "1", "--rps-by", "tenant=4")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	a := starts(t, path)["a"]
+	require.Len(t, a, 4)
+	for i := 1; i < len(a); i++ {
+		assert.GreaterOrEqualf(t, a[i]-a[i-1], int64(200), "a ran faster than its limit: %v", a)
+	}
+}
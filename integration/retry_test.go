@@ -0,0 +1,174 @@
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// retries wait longer each time
+func TestRetryBackoff(t *testing.T) {
+	start := time.Now()
+	r := run(t, `{"a":1}`, "--exec", "exit 1", "--retries", "2",
+		"--retry-base", "200ms", "--retry-jitter", "none")
+	elapsed := time.Since(start)
+	assert.NotEqual(t, 0, r.exitCode)
+	// 200ms then 400ms
+	assert.GreaterOrEqual(t, elapsed, 600*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+}
+
+func TestRetryMaxDelay(t *testing.T) {
+	start := time.Now()
+	r := run(t, `{"a":1}`, "--exec", "exit 1", "--retries", "3",
+		"--retry-base", "100ms", "--retry-max-delay", "100ms", "--retry-jitter", "none")
+	elapsed := time.Since(start)
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+}
+
+func TestRetryJitterInvalid(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--retry-jitter", "lots")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, `unknown retry jitter "lots"`)
+}
+
+// each attempt's exit code and duration is logged
+func TestRetryAttemptsLogged(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, `{"a":1}`, "--exec", `[ "$SE_ATTEMPT" = 3 ] || exit $SE_ATTEMPT`, "--retries", "3",
+		"--retry-base", "10ms", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	b, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	var res struct {
+		Attempts []struct {
+			ExitCode int
+			Duration time.Duration
+		}
+	}
+	require.NoError(t, json.Unmarshal(b, &res))
+	require.Len(t, res.Attempts, 3)
+	for i, expected := range []int{1, 2, 0} {
+		assert.Equal(t, expected, res.Attempts[i].ExitCode)
+		assert.Positive(t, res.Attempts[i].Duration)
+	}
+}
+
+// failedResults parses the results of failed commands reported on stderr
+func failedResults(t *testing.T, stderr string) []struct{ Attempts []struct{ ExitCode int } } {
+	t.Helper()
+	var results []struct{ Attempts []struct{ ExitCode int } }
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		var res struct{ Attempts []struct{ ExitCode int } }
+		require.NoError(t, json.Unmarshal([]byte(line), &res), line)
+		results = append(results, res)
+	}
+	return results
+}
+
+func TestRetryOnExit(t *testing.T) {
+	tests := map[string]struct {
+		exitCode int
+		expected int
+	}{
+		"transient failure is retried": {exitCode: 7, expected: 3},
+		"permanent failure fails fast": {exitCode: 22, expected: 1},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := run(t, `{"a":1}`, "--exec", fmt.Sprintf("exit %d", td.exitCode),
+				"--retries", "2", "--retry-base", "10ms", "--retry-on-exit", "7,28")
+			assert.NotEqual(t, 0, r.exitCode)
+			results := failedResults(t, r.stderr)
+			require.Len(t, results, 1)
+			assert.Len(t, results[0].Attempts, td.expected)
+		})
+	}
+}
+
+func TestRetryOnOutput(t *testing.T) {
+	r := run(t, "{\"code\":503}\n{\"code\":404}\n", "--exec", `echo "HTTP $code" >&2; exit 22`,
+		"--retries", "2", "--retry-base", "10ms", "--retry-on-output", `HTTP 5\d\d`, "--continue", "--concurrency", "1")
+	assert.Equal(t, 0, r.exitCode)
+	results := failedResults(t, r.stderr)
+	require.Len(t, results, 2)
+	assert.Len(t, results[0].Attempts, 3, "503 should be retried")
+	assert.Len(t, results[1].Attempts, 1, "404 shouldn't be retried")
+}
+
+func TestNoRetryOnExit(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "exit 22",
+		"--retries", "2", "--retry-base", "10ms", "--no-retry-on-exit", "22")
+	assert.NotEqual(t, 0, r.exitCode)
+	results := failedResults(t, r.stderr)
+	require.Len(t, results, 1)
+	assert.Len(t, results[0].Attempts, 1)
+}
+
+func TestRetryOnOutputInvalid(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--retry-on-output", "(")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "invalid --retry-on-output")
+}
+
+// a command can say how long to wait before it's retried
+func TestRetryAfterHint(t *testing.T) {
+	start := time.Now()
+	r := run(t, `{"a":1}`, "--exec", `[ "$SE_ATTEMPT" = 2 ] && echo done && exit 0; echo STREAM_EXEC_RETRY_AFTER=500ms >&2; exit 75`,
+		"--retries", "1", "--retry-base", "10ms", "--retry-jitter", "none", "--retry-on-exit", "75")
+	elapsed := time.Since(start)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "done")
+	assert.GreaterOrEqual(t, elapsed, 500*time.Millisecond)
+}
+
+// with --pause-on-retry-after, the hint holds up other records as well
+func TestPauseOnRetryAfter(t *testing.T) {
+	input := "{\"a\":1}\n{\"a\":2}\n"
+	exec := `[ $a = 2 ] && exit 0; echo STREAM_EXEC_RETRY_AFTER=700ms >&2; exit 75`
+
+	start := time.Now()
+	r := run(t, input, "--exec", exec, "--concurrency", "1", "--continue")
+	assert.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Less(t, time.Since(start), 700*time.Millisecond)
+
+	start = time.Now()
+	r = run(t, input, "--exec", exec, "--concurrency", "1", "--continue", "--pause-on-retry-after")
+	assert.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.GreaterOrEqual(t, time.Since(start), 700*time.Millisecond)
+	assert.Contains(t, r.stderr, `"RetryAfter":700000000`)
+}
+
+// a hint is only followed if the retry policy allows a retry
+func TestRetryAfterHintNotRetryable(t *testing.T) {
+	start := time.Now()
+	r := run(t, `{"a":1}`, "--exec", `echo STREAM_EXEC_RETRY_AFTER=10s >&2; exit 75`,
+		"--retries", "1", "--retry-on-exit", "7", "--pause-on-retry-after")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	results := failedResults(t, r.stderr)
+	require.Len(t, results, 1)
+	assert.Len(t, results[0].Attempts, 1)
+}
+
+// a command can't hold up the run for longer than --retry-max-delay
+func TestRetryAfterHintCapped(t *testing.T) {
+	input := "{\"a\":1}\n{\"a\":2}\n"
+	start := time.Now()
+	r := run(t, input, "--exec", `[ $a = 2 ] || [ "$SE_ATTEMPT" = 2 ] && exit 0; echo STREAM_EXEC_RETRY_AFTER=87600h >&2; exit 75`,
+		"--retries", "1", "--retry-max-delay", "300ms", "--pause-on-retry-after")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 300*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+}
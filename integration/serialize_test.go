@@ -0,0 +1,105 @@
+package integration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerializeBy(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 12; i++ {
+		fmt.Fprintf(&input, `{"user":"u%d","seq":%d}`+"\n", i%3, i)
+	}
+	events := filepath.Join(t.TempDir(), "events")
+	r := run(t, input.String(), "--exec", `echo "start $user $seq" >> `+events+`; sleep 0.05; echo "end $user $seq" >> `+events,
+		"--concurrency", "6", "--serialize-by", "user")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+
+	b, err := os.ReadFile(events)
+	require.NoError(t, err)
+	running := map[string]int{} // user -> seq running
+	lastSeq := map[string]int{}
+	maxUsers := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var event, user string
+		var seq int
+		_, err := fmt.Sscanf(line, "%s %s %d", &event, &user, &seq)
+		require.NoError(t, err)
+		if event == "start" {
+			_, busy := running[user]
+			assert.Falsef(t, busy, "%s started %d while another was running", user, seq)
+			if last, ok := lastSeq[user]; ok {
+				assert.Greaterf(t, seq, last, "%s ran out of order", user)
+			}
+			running[user], lastSeq[user] = seq, seq
+			maxUsers = max(maxUsers, len(running))
+		} else {
+			delete(running, user)
+		}
+	}
+	assert.Len(t, lastSeq, 3)
+	assert.Greater(t, maxUsers, 1, "different users should run concurrently")
+}
+
+func TestSerializeByBatchConflict(t *testing.T) {
+	r := run(t, seqInput(2), "--exec", "cat", "--serialize-by", "seq", "--batch-size", "2")
+	assert.Equal(t, 1, r.exitCode)
+	assert.Contains(t, r.stderr, "--serialize-by and --batch-size can't be used together")
+}
+
+// seqOutput is what echoing $seq for seqInput's records prints, in order
+func seqOutput(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "%d\n", i)
+	}
+	return sb.String()
+}
+
+func TestSerializeByKeepOrder(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&input, `{"user":"u%d","seq":%d}`+"\n", i%3, i)
+	}
+	r := run(t, input.String(), "--exec", `sleep 0.0$(( (20 - seq) % 5 )); echo $seq`,
+		"--concurrency", "4", "--serialize-by", "user", "--keep-order", "--reorder-window", "1")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, seqOutput(20), r.stdout)
+}
+
+// records queued behind a key still wait for the reorder window
+func TestSerializeByKeepOrderWindow(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 8; i++ {
+		user := "b"
+		if i == 0 {
+			user = "a"
+		}
+		fmt.Fprintf(&input, `{"user":"%s","seq":%d}`+"\n", user, i)
+	}
+	starts := filepath.Join(t.TempDir(), "starts")
+	r := run(t, input.String(), "--exec", `echo "$seq $(date +%s%3N)" >> `+starts+`; [ $seq = 0 ] && sleep 1; echo $seq`,
+		"--concurrency", "4", "--serialize-by", "user", "--keep-order", "--reorder-window", "3")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, seqOutput(8), r.stdout)
+
+	b, err := os.ReadFile(starts)
+	require.NoError(t, err)
+	startedAt := map[int]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var seq int
+		var ms int64
+		_, err := fmt.Sscanf(line, "%d %d", &seq, &ms)
+		require.NoError(t, err)
+		startedAt[seq] = ms
+	}
+	for seq := 3; seq < 8; seq++ {
+		assert.GreaterOrEqualf(t, startedAt[seq]-startedAt[0], int64(900), "%d started within a second of 0", seq)
+	}
+}
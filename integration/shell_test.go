@@ -0,0 +1,45 @@
+package integration
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellSh(t *testing.T) {
+	r := run(t, `{"name":"alice"}`, "--exec", `echo "hello $name"`, "--shell", "sh -c")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "hello alice")
+}
+
+// the exec string can be written in another language entirely
+func TestShellPython(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not available")
+	}
+	r := run(t, `{"name":"alice"}`, "--exec", `import os; print(os.environ["name"].upper())`, "--shell", "python3 -c")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "ALICE")
+}
+
+// the shell is recorded alongside the exec string in the output log
+func TestShellRecordedInOutputLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--shell", "sh -c", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	log, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(log), `"Params":{"Shell":"sh -c","ExecString":"echo $a"`)
+}
+
+// a missing interpreter is reported before any input is consumed
+func TestShellMissingInterpreter(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--shell", "no-such-shell-here -c")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, `shell "no-such-shell-here -c" is not available`)
+	assert.Empty(t, r.stdout)
+}
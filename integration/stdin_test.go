@@ -0,0 +1,60 @@
+package integration
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// by default the command's stdin is empty
+func TestStdinNoneByDefault(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", `echo "[$(cat)]"`)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "[]")
+}
+
+func TestStdinRecord(t *testing.T) {
+	r := run(t, `{"a": 1, "b": "x"}`, "--exec", `cat`, "--stdin", "record")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, `{"a": 1, "b": "x"}`+"\n", r.stdout)
+}
+
+// the record on stdin doesn't change the environment; that's up to --no-env
+func TestStdinRecordEnv(t *testing.T) {
+	r := run(t, `{"a": 1, "secret": "x"}`, "--exec", `env; cat >/dev/null`, "--stdin", "record")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "a=1")
+	assert.Contains(t, r.stdout, "secret=x")
+	assert.Contains(t, r.stdout, "SE_RECORD=")
+
+	r = run(t, `{"a": 1, "secret": "x"}`, "--exec", `env; cat >/dev/null`, "--stdin", "record", "--no-env")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.NotContains(t, r.stdout, "a=1")
+	assert.NotContains(t, r.stdout, "secret=")
+	assert.NotContains(t, r.stdout, "SE_RECORD=")
+	assert.Contains(t, r.stdout, "SE_INDEX=0")
+}
+
+func TestStdinField(t *testing.T) {
+	r := run(t, `{"id": 1, "payload": {"k": "v"}}`, "--exec", `cat; echo " $id"`, "--stdin", "field:payload")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, `{"k":"v"} 1`)
+}
+
+// records too large for the environment can still be handled via stdin with --no-env
+func TestStdinLargeRecordWithNoEnv(t *testing.T) {
+	large := strings.Repeat("x", 4*1024*1024)
+	input := fmt.Sprintf(`{"id":"a","payload":"%s"}`, large)
+	r := run(t, input, "--exec", `echo "[$id] [$SE_RECORD] $SE_INDEX $(wc -c)"`, "--stdin", "record", "--no-env")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, fmt.Sprintf("[] [] 0 %d", len(input)+1))
+}
+
+func TestStdinInvalidMode(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "cat", "--stdin", "bogus")
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, "invalid --stdin")
+}
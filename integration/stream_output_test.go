@@ -0,0 +1,84 @@
+package integration
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamOutputPrefixesLines(t *testing.T) {
+	r := run(t, "{\"a\":1}\n{\"a\":2}\n", "--exec", "echo hello $a; echo oops >&2", "--stream-output")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "[0] hello 1\n")
+	assert.Contains(t, r.stdout, "[1] hello 2\n")
+	assert.Contains(t, r.stderr, "[0] oops\n")
+	assert.Contains(t, r.stderr, "[1] oops\n")
+}
+
+func TestStreamOutputCustomPrefix(t *testing.T) {
+	r := run(t, `{"city":"New York"}`, "--exec", "printf 'one\ntwo'", "--stream-output", "--stream-prefix", "$city: ")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "New York: one\nNew York: two\n", r.stdout)
+}
+
+func TestStreamOutputMetaPrefix(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", "echo hi", "--stream-output", "--meta-prefix", "X_")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, "[0] hi\n", r.stdout)
+}
+
+// concurrent commands don't split each other's lines
+func TestStreamOutputLinesAreWhole(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 8; i++ {
+		input.WriteString(`{"a":1}` + "\n")
+	}
+	r := run(t, input.String(), "--exec", `for i in $(seq 200); do echo "abcdefghijklmnopqrstuvwxyz"; done`,
+		"--stream-output", "--concurrency", "8")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	lines := strings.Split(strings.TrimSuffix(r.stdout, "\n"), "\n")
+	assert.Len(t, lines, 1600)
+	for _, l := range lines {
+		require.Regexp(t, `^\[\d\] abcdefghijklmnopqrstuvwxyz$`, l)
+	}
+}
+
+// output shows up while the command is still running
+func TestStreamOutputIsLive(t *testing.T) {
+	cmd := exec.Command(binaryPath, "run", "--exec", "echo started; sleep 5", "--stream-output")
+	cmd.Stdin = bytes.NewBufferString(`{"a":1}`)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	defer cmd.Process.Kill()
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(stdout).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		assert.Equal(t, "[0] started\n", line)
+	case <-time.After(3 * time.Second):
+		t.Fatal("no output while the command was running")
+	}
+}
+
+// the output log still gets the full output
+func TestStreamOutputLogged(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, `{"a":1}`, "--exec", "echo hi", "--stream-output", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	log, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(log), `"Stdout":"hi\n"`)
+}
@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeoutKillsHungCommand(t *testing.T) {
+	start := time.Now()
+	r := run(t, `{"a":1}`, "--exec", "sleep 10", "--timeout", "200ms")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.NotEqual(t, 0, r.exitCode)
+	assert.Contains(t, r.stderr, `"TimedOut":true`)
+	assert.Contains(t, r.stderr, "timed out after 200ms")
+}
+
+func TestTimeoutNotHit(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "out.log")
+	r := run(t, `{"a":1}`, "--exec", "echo $a", "--timeout", "10s", "--output-log-path", logPath)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "1")
+	log, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(log), "TimedOut")
+	assert.Contains(t, string(log), `"Duration":`)
+}
+
+// everything the command started is killed, not just the shell
+func TestTimeoutKillsGrandchildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	r := run(t, `{"a":1}`, "--exec", `sleep 30 & echo $! > `+pidFile+`; wait`, "--timeout", "300ms")
+	assert.Contains(t, r.stderr, `"TimedOut":true`)
+
+	b, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return !running(pid) }, 2*time.Second, 50*time.Millisecond,
+		"grandchild %d still running", pid)
+}
+
+// a grandchild that ignores SIGTERM and has let go of the output pipes is
+// still killed once the shell has exited
+func TestTimeoutKillsStragglers(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	r := run(t, `{"a":1}`, "--exec", `(trap "" TERM; exec sleep 30) >/dev/null 2>&1 & echo $! > `+pidFile+`; wait`,
+		"--timeout", "300ms", "--kill-grace", "10s")
+	assert.Contains(t, r.stderr, `"TimedOut":true`)
+
+	b, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return !running(pid) }, 2*time.Second, 50*time.Millisecond,
+		"grandchild %d still running", pid)
+}
+
+// running reports whether pid is alive. Orphans that have been killed may
+// linger as zombies if nothing reaps them, so those count as dead.
+func running(pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true // no procfs, e.g. macOS; assume the worst
+	}
+	// the state follows the command name, which is in parentheses
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
+
+// a command that ignores SIGTERM is killed once the grace period is up
+func TestTimeoutKillGrace(t *testing.T) {
+	start := time.Now()
+	r := run(t, `{"a":1}`, "--exec", `trap "" TERM; sleep 10`, "--timeout", "200ms", "--kill-grace", "500ms")
+	elapsed := time.Since(start)
+	assert.Contains(t, r.stderr, `"TimedOut":true`)
+	assert.GreaterOrEqual(t, elapsed, 700*time.Millisecond)
+	assert.Less(t, elapsed, 5*time.Second)
+}
+
+// each attempt gets the full timeout
+func TestTimeoutRetried(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", `[ "$SE_ATTEMPT" -gt 1 ] || sleep 10; echo attempt $SE_ATTEMPT`, "--timeout", "200ms", "--retries", "1")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Contains(t, r.stdout, "attempt 2")
+}
+
+// commands only get their own process group when they can be timed out;
+// otherwise they share stream-exec's, so Ctrl-C reaches them as before
+func TestProcessGroupOnlyWithTimeout(t *testing.T) {
+	r := run(t, `{"a":1}`, "--exec", `ps -o pgid= -p $$`)
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	assert.Equal(t, fmt.Sprint(syscall.Getpgrp()), strings.TrimSpace(r.stdout))
+
+	r = run(t, `{"a":1}`, "--exec", `echo $$; ps -o pgid= -p $$`, "--timeout", "10s")
+	require.Equal(t, 0, r.exitCode, "stderr: %s", r.stderr)
+	fields := strings.Fields(r.stdout)
+	require.Len(t, fields, 2)
+	assert.Equal(t, fields[0], fields[1])
+}
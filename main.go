@@ -2,11 +2,13 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -18,18 +20,71 @@ import (
 const (
 	_flagExecCmd          = "exec"
 	_flagConcurrency      = "concurrency"
+	_flagMinConcurrency   = "min-concurrency"
+	_flagMaxConcurrency   = "max-concurrency"
+	_flagAutoInterval     = "auto-concurrency-interval"
+	_flagKeepOrder        = "keep-order"
+	_flagReorderWindow    = "reorder-window"
+	_flagSerializeBy      = "serialize-by"
+	_flagMaxPendingKeys   = "max-pending-keys"
+	_flagBatchSize        = "batch-size"
+	_flagBatchTimeout     = "batch-timeout"
+	_flagBatchInput       = "batch-input"
 	_flagRetries          = "retries"
+	_flagRetryBase        = "retry-base"
+	_flagRetryMaxDelay    = "retry-max-delay"
+	_flagRetryJitter      = "retry-jitter"
+	_flagRetryOnExit      = "retry-on-exit"
+	_flagRetryOnOutput    = "retry-on-output"
+	_flagNoRetryOnExit    = "no-retry-on-exit"
+	_flagPauseRetryAfter  = "pause-on-retry-after"
+	_flagBreakerFailures  = "breaker-failures"
+	_flagBreakerRatio     = "breaker-failure-ratio"
+	_flagBreakerWindow    = "breaker-window"
+	_flagBreakerCooldown  = "breaker-cooldown"
+	_flagTimeout          = "timeout"
+	_flagKillGrace        = "kill-grace"
 	_flagContinue         = "continue"
 	_flagDryRun           = "dry-run"
 	_flagDebug            = "debug"
 	_flagOutputLogPath    = "output-log-path"
+	_flagTextOutput       = "text-output"
+	_flagStreamOutput     = "stream-output"
+	_flagStreamPrefix     = "stream-prefix"
+	_flagMaxOutputBytes   = "max-output-bytes"
+	_flagOutputDir        = "output-dir"
 	_flagInputFile        = "input-json-file"
 	_flagRPS              = "rps"
+	_flagRPSBy            = "rps-by"
+	_flagRPSByMaxKeys     = "rps-by-max-keys"
+	_flagRPSByMaxPending  = "rps-by-max-pending"
+	_flagInputFormat      = "input-format"
+	_flagDelimiter        = "delimiter"
+	_flagLazyQuotes       = "lazy-quotes"
+	_flagNoHeader         = "no-header"
+	_flagFollow           = "follow"
+	_flagLineVar          = "line-var"
+	_flagNullDelimited    = "null"
+	_flagFlatten          = "flatten"
+	_flagFlattenSep       = "flatten-separator"
+	_flagFlattenDepth     = "flatten-max-depth"
+	_flagExactNumbers     = "exact-numbers"
+	_flagMetaPrefix       = "meta-prefix"
+	_flagRunID            = "run-id"
+	_flagStdin            = "stdin"
+	_flagArgv             = "argv"
+	_flagShell            = "shell"
+	_flagNoEnv            = "no-env"
 	_flagPID              = "pid"
 	_flagSetConcurrency   = "concurrency"
 	_ipcCmdStatus         = "status"
 	_ipcCmdStop           = "stop"
 	_ipcCmdSetConcurrency = "set-concurrency"
+
+	_inputFormatJSON  = "json"
+	_inputFormatCSV   = "csv"
+	_inputFormatTSV   = "tsv"
+	_inputFormatLines = "lines"
 )
 
 func main() {
@@ -52,12 +107,12 @@ func cmdRun() *cli.Command {
 	return &cli.Command{
 		Name:      "run",
 		Usage:     "read JSON lines from stdin and execute a command for each",
-		ArgsUsage: " ", // stdin is the input
+		ArgsUsage: "[--argv -- command {field} ...]", // stdin is the input
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    _flagExecCmd,
 				Aliases: []string{"x", "exec-command"},
-				Usage: `bash command to run for each record (required). 
+				Usage: `bash command to run for each record (required unless --argv is given). 
 JSON variables for each line of input will be available as their key name. For example, given an input of JSON lines:
 
 {"user": "alice"} 
@@ -70,13 +125,76 @@ Therefore, for a quick way to download all the user info from an API:
 The command -x 'curl http://somesite/$user' should output each line to stdout.
 
 Keys are normalised to make them safe for use in shell, so the key 'a-b' is available in shell as 'a_b'. All non-alphanumerics are replaced with underscore.`,
-				Required: true,
 			},
-			&cli.IntFlag{
+			&cli.StringFlag{
+				Name: _flagShell,
+				Usage: `interpreter the --exec string is passed to as its final argument, e.g. 'sh -c', 'zsh -c', 'python3 -c' or 'node -e'.
+The interpreter must be on the PATH`,
+				Value: "bash -c",
+			},
+			&cli.BoolFlag{
+				Name: _flagArgv,
+				Usage: `run the command given as arguments after '--' directly, without a shell, instead of --exec.
+{field} in any argument is replaced with that field's value, which stays part of the one argument however it's spaced or quoted.
+Use {{ and }} for literal braces. For example: --argv -- curl --fail 'https://goweather.xyz/v2/weather/{city}'`,
+			},
+			&cli.StringFlag{
 				Name:    _flagConcurrency,
 				Aliases: []string{"c"},
-				Usage:   "How many bash commands to run concurrently.",
-				Value:   1,
+				Usage: `How many bash commands to run concurrently.
+'auto' adjusts it between --min-concurrency and --max-concurrency: up by one every --auto-concurrency-interval while commands succeed, halved when more than 5% fail or they slow down`,
+				Value: "1",
+			},
+			&cli.IntFlag{
+				Name:  _flagMinConcurrency,
+				Usage: "with --concurrency auto, the lowest concurrency, and where it starts",
+				Value: 1,
+			},
+			&cli.IntFlag{
+				Name:  _flagMaxConcurrency,
+				Usage: "with --concurrency auto, the highest concurrency",
+				Value: 100,
+			},
+			&cli.DurationFlag{
+				Name:  _flagAutoInterval,
+				Usage: "with --concurrency auto, how often concurrency is adjusted",
+				Value: 5 * time.Second,
+			},
+			&cli.BoolFlag{
+				Name:  _flagKeepOrder,
+				Usage: "write results in the same order as the input, rather than as commands finish",
+			},
+			&cli.IntFlag{
+				Name: _flagReorderWindow,
+				Usage: `with --keep-order, how many records past the oldest one still running can be started.
+Results that finish early are held in memory until they can be written, so this limits how many are held`,
+				Value: 1000,
+			},
+			&cli.StringFlag{
+				Name: _flagSerializeBy,
+				Usage: `records with the same value for this ` + "`field`" + ` are run one at a time, in input order, while those with different values still run concurrently.
+Records without the field aren't held back`,
+			},
+			&cli.IntFlag{
+				Name: _flagMaxPendingKeys,
+				Usage: `with --serialize-by, how many values can have records waiting for an earlier one to finish.
+Once reached, reading the input waits until one clears`,
+				Value: 1000,
+			},
+			&cli.IntFlag{
+				Name:  _flagBatchSize,
+				Usage: "run one command for each group of this many records, rather than one per record",
+				Value: 1,
+			},
+			&cli.DurationFlag{
+				Name:  _flagBatchTimeout,
+				Usage: "with --batch-size, run a partial batch once its first record has waited this long (default: wait until the batch is full)",
+			},
+			&cli.StringFlag{
+				Name: _flagBatchInput,
+				Usage: `with --batch-size, how each command gets its records: 'stdin' (a JSON array), 'file' (a JSON array in a temporary file
+whose path is in $SE_BATCH_FILE) or 'env' (envvars suffixed with each record's position in the batch, like $user_0, $user_1)`,
+				Value: streamexec.BatchInputStdin,
 			},
 			&cli.IntFlag{
 				Name:    _flagRetries,
@@ -84,6 +202,69 @@ Keys are normalised to make them safe for use in shell, so the key 'a-b' is avai
 				Usage:   "number of times to retry a failed command (if the command exit-codes is not zero)",
 				Value:   0,
 			},
+			&cli.DurationFlag{
+				Name:  _flagRetryBase,
+				Usage: "delay before the first retry. It doubles for each retry after that, up to --retry-max-delay",
+				Value: time.Second,
+			},
+			&cli.DurationFlag{
+				Name:  _flagRetryMaxDelay,
+				Usage: "longest delay between retries, including those commands ask for with STREAM_EXEC_RETRY_AFTER",
+				Value: time.Minute,
+			},
+			&cli.StringFlag{
+				Name: _flagRetryJitter,
+				Usage: `how retry delays are randomised, so commands that fail together don't all retry together:
+'full' (anywhere up to the exponential delay), 'decorrelated' (between --retry-base and 3x the previous delay) or 'none'`,
+				Value: streamexec.JitterFull,
+			},
+			&cli.IntSliceFlag{
+				Name: _flagRetryOnExit,
+				Usage: `only retry commands that fail with one of these exit codes, e.g. 7,28 for curl's connection failures and timeouts.
+Commands killed by --timeout are retried too`,
+			},
+			&cli.StringFlag{
+				Name:  _flagRetryOnOutput,
+				Usage: "only retry commands whose stdout or stderr matches this `regex` (or that fail with a --retry-on-exit code)",
+			},
+			&cli.IntSliceFlag{
+				Name:  _flagNoRetryOnExit,
+				Usage: "never retry commands that fail with one of these exit codes",
+			},
+			&cli.BoolFlag{
+				Name: _flagPauseRetryAfter,
+				Usage: `when a command asks to be retried later by writing a line like 'STREAM_EXEC_RETRY_AFTER=30s' to stderr,
+hold off starting any other commands until then too, e.g. when an API is rate limiting all requests`,
+			},
+			&cli.IntFlag{
+				Name: _flagBreakerFailures,
+				Usage: `circuit breaker: once this many commands have failed within --breaker-window, stop starting new ones for --breaker-cooldown,
+then run a single command to check things have recovered before carrying on. Useful with --continue, so an outage doesn't fail the whole input`,
+			},
+			&cli.Float64Flag{
+				Name:  _flagBreakerRatio,
+				Usage: "circuit breaker: trip once this fraction (0-1) of the commands finishing within --breaker-window have failed, given at least 10",
+			},
+			&cli.DurationFlag{
+				Name:  _flagBreakerWindow,
+				Usage: "circuit breaker: how far back failures are counted",
+				Value: time.Minute,
+			},
+			&cli.DurationFlag{
+				Name:  _flagBreakerCooldown,
+				Usage: "circuit breaker: how long to pause once tripped",
+				Value: 30 * time.Second,
+			},
+			&cli.DurationFlag{
+				Name: _flagTimeout,
+				Usage: `kill a command that runs for longer than this, e.g. 30s. Applies to each attempt, so a timed out command can still be retried.
+The command and everything it started are sent SIGTERM, then SIGKILL if still running after --kill-grace. 0 means no limit`,
+			},
+			&cli.DurationFlag{
+				Name:  _flagKillGrace,
+				Usage: "how long a command gets to exit after SIGTERM before it's sent SIGKILL",
+				Value: 5 * time.Second,
+			},
 			&cli.BoolFlag{
 				Name:    _flagContinue,
 				Aliases: []string{"k"},
@@ -97,42 +278,362 @@ Keys are normalised to make them safe for use in shell, so the key 'a-b' is avai
 				Name:  _flagDebug,
 				Usage: "print env vars and parameters for each execution",
 			},
+			&cli.StringFlag{
+				Name: _flagTextOutput,
+				Usage: `what's printed for each successful command: 'interleaved' for its stdout and stderr in the order they were written, or 'stdout' for just stdout.
+Both are kept separately in the output log either way`,
+				Value: streamexec.TextOutputInterleaved,
+			},
+			&cli.BoolFlag{
+				Name:  _flagStreamOutput,
+				Usage: "print each line of a command's output as soon as it's written, rather than all at once when the command exits",
+			},
+			&cli.StringFlag{
+				Name: _flagStreamPrefix,
+				Usage: `with --stream-output, put in front of each line to show which record it came from. $variables are expanded as for the command,
+e.g. '$city: '. Defaults to '[$SE_INDEX] '`,
+			},
+			&cli.Int64Flag{
+				Name: _flagMaxOutputBytes,
+				Usage: `keep at most this many bytes of each command's stdout, and of its stderr, for the text output and output log.
+Anything more is left out and the number of bytes dropped recorded. 0 means no limit`,
+			},
+			&cli.StringFlag{
+				Name: _flagOutputDir,
+				Usage: "write each command's full stdout and stderr to files in `dir`, named <run id>-<index>.stdout and .stderr. " +
+					"The output log then records the files' paths in place of the output",
+			},
 			&cli.StringFlag{
 				Name:  _flagOutputLogPath,
 				Usage: "write successful results as JSON lines to `file`",
 			},
-			&cli.StringFlag{
-				Name:  _flagInputFile,
-				Usage: "read input from a JSON `file` instead of stdin (JSON lines or a top-level JSON array)",
+			&cli.StringSliceFlag{
+				Name: _flagInputFile,
+				Usage: `read input from a JSON ` + "`file`" + ` instead of stdin (JSON lines or a top-level JSON array, optionally gzip, zstd, bzip2 or xz compressed).
+May be repeated or given as a glob pattern such as 'exports/*.jsonl'; the files are read one after the other as a single stream.
+The name of the file and the line number of each record are available to the command as $SE_SOURCE_FILE and $SE_LINE.`,
 			},
 			&cli.Float64Flag{
 				Name:  _flagRPS,
 				Usage: "max executions per second across all workers (0 = unlimited)",
 				Value: 0,
 			},
+			&cli.StringFlag{
+				Name: _flagRPSBy,
+				Usage: `as '<field>=<rate>', max executions per second for each value of a field, as well as --rps overall.
+Use '<field>:host=<rate>' to limit by the host of a URL in the field, for example --rps-by url:host=5`,
+			},
+			&cli.IntFlag{
+				Name:  _flagRPSByMaxKeys,
+				Usage: "with --rps-by, how many values to track; beyond this the least recently seen are forgotten once idle, 1/rate seconds after their last command",
+				Value: 10000,
+			},
+			&cli.IntFlag{
+				Name: _flagRPSByMaxPending,
+				Usage: `with --rps-by, how many records can be held back waiting for their value's limit.
+Once reached, reading the input waits until one is due`,
+				Value: 10000,
+			},
+			&cli.BoolFlag{
+				Name: _flagFlatten,
+				Usage: `also expose the members of nested objects and arrays as their own variables.
+{"user": {"id": 1}, "tags": ["a", "b"]} gives $user_id, $tags_0, $tags_1 and $tags_len as well as $user and $tags`,
+			},
+			&cli.BoolFlag{
+				Name:  _flagExactNumbers,
+				Usage: "pass numbers through exactly as written in the input, e.g. 1.50 and 1e3 rather than 1.5 and 1000. Integers are always passed through exactly",
+			},
+			&cli.StringFlag{
+				Name: _flagMetaPrefix,
+				Usage: `prefix of the variables stream-exec sets alongside the record's own:
+$SE_RECORD (the record as it appeared in the input), $SE_INDEX (0-based position in the input), $SE_LINE, $SE_SOURCE_FILE,
+$SE_ATTEMPT (1 for the first try, 2 for the first retry...), $SE_WORKER and $SE_RUN_ID`,
+				Value: "SE_",
+			},
+			&cli.StringFlag{
+				Name:  _flagRunID,
+				Usage: "identifier for this run, exposed as $SE_RUN_ID (default: randomly generated)",
+			},
+			&cli.StringFlag{
+				Name: _flagStdin,
+				Usage: `what to write to each command's stdin: 'none', 'record' (the record as it appeared in the input)
+or 'field:<name>' (the value of one field). Useful for records too large to pass as envvars, see --no-env`,
+				Value: "none",
+			},
+			&cli.BoolFlag{
+				Name:  _flagNoEnv,
+				Usage: "don't pass the record's values as envvars, only the metadata ($SE_INDEX etc., but not $SE_RECORD)",
+			},
+			&cli.StringFlag{
+				Name:  _flagFlattenSep,
+				Usage: "with --flatten, the separator between the keys of nested values",
+				Value: "_",
+			},
+			&cli.IntFlag{
+				Name:  _flagFlattenDepth,
+				Usage: "with --flatten, how many levels of nesting to flatten (0 = unlimited); deeper values are left as JSON",
+			},
+			&cli.BoolFlag{
+				Name:    _flagFollow,
+				Aliases: []string{"F"},
+				Usage: `keep reading lines appended to the input file rather than stopping at the end of it, like 'tail -F'.
+Survives the file being truncated or rotated. Runs until stopped with 'stream-exec signal stop' or Ctrl-C.
+Requires a single, uncompressed --input-json-file`,
+			},
+			&cli.StringFlag{
+				Name:  _flagInputFormat,
+				Usage: "format of the input: json, csv, tsv or lines. For csv/tsv the header row provides the variable names; with lines each line of text is a single variable (see --line-var)",
+				Value: _inputFormatJSON,
+			},
+			&cli.StringFlag{
+				Name:  _flagDelimiter,
+				Usage: "field delimiter for csv/tsv input (defaults to ',' for csv and tab for tsv)",
+			},
+			&cli.BoolFlag{
+				Name:  _flagLazyQuotes,
+				Usage: "for csv/tsv input, allow quotes to appear in unquoted fields and non-doubled quotes in quoted fields",
+			},
+			&cli.BoolFlag{
+				Name:  _flagNoHeader,
+				Usage: "for csv/tsv input, treat the first row as data and expose columns as $c1, $c2, ...",
+			},
+			&cli.StringFlag{
+				Name:  _flagLineVar,
+				Usage: "for lines input, the variable each line is exposed as",
+				Value: "line",
+			},
+			&cli.BoolFlag{
+				Name:    _flagNullDelimited,
+				Aliases: []string{"0"},
+				Usage:   "for lines input, lines are separated by NUL rather than newline (as from 'find -print0')",
+			},
 		},
 		Action: func(c *cli.Context) error {
 			options := streamexec.Options{
 				OutputLog: c.String(_flagOutputLogPath),
 				Params: streamexec.Params{
+					Shell:      c.String(_flagShell),
 					ExecString: c.String(_flagExecCmd),
 					Retries:    c.Int(_flagRetries),
+					Timeout:    c.Duration(_flagTimeout),
+				},
+				KillGrace:  c.Duration(_flagKillGrace),
+				TextOutput: c.String(_flagTextOutput),
+				Backoff: streamexec.BackoffOptions{
+					Base:     c.Duration(_flagRetryBase),
+					MaxDelay: c.Duration(_flagRetryMaxDelay),
+					Jitter:   c.String(_flagRetryJitter),
 				},
-				Concurrency:   c.Int(_flagConcurrency),
-				ContinueOnErr: c.Bool(_flagContinue),
-				DebugMode:     c.Bool(_flagDebug),
-				DryRun:        c.Bool(_flagDryRun),
-				RPS:           c.Float64(_flagRPS),
+				StreamOutput:   c.Bool(_flagStreamOutput),
+				StreamPrefix:   c.String(_flagStreamPrefix),
+				MaxOutputBytes: c.Int64(_flagMaxOutputBytes),
+				OutputDir:      c.String(_flagOutputDir),
+				ContinueOnErr:  c.Bool(_flagContinue),
+				DebugMode:      c.Bool(_flagDebug),
+				DryRun:         c.Bool(_flagDryRun),
+				RPS:            c.Float64(_flagRPS),
+				ExactNumbers:   c.Bool(_flagExactNumbers),
+				MetaPrefix:     c.String(_flagMetaPrefix),
+				RunID:          c.String(_flagRunID),
+				Flatten: streamexec.FlattenOptions{
+					Enabled:   c.Bool(_flagFlatten),
+					Separator: c.String(_flagFlattenSep),
+					MaxDepth:  c.Int(_flagFlattenDepth),
+				},
+			}
+			if !c.IsSet(_flagStreamPrefix) {
+				options.StreamPrefix = "[$" + options.MetaPrefix + "INDEX] "
+			}
+			if c.String(_flagConcurrency) == "auto" {
+				options.AutoConcurrency = streamexec.AutoConcurrencyOptions{
+					Enabled:  true,
+					Min:      c.Int(_flagMinConcurrency),
+					Max:      c.Int(_flagMaxConcurrency),
+					Interval: c.Duration(_flagAutoInterval),
+				}
+				if options.AutoConcurrency.Min < 1 || options.AutoConcurrency.Max < options.AutoConcurrency.Min {
+					return cli.Exit(fmt.Sprintf("invalid concurrency range %d-%d", options.AutoConcurrency.Min, options.AutoConcurrency.Max), 1)
+				}
+			} else {
+				n, err := strconv.Atoi(c.String(_flagConcurrency))
+				if err != nil || n < 0 {
+					return cli.Exit(fmt.Sprintf("invalid concurrency %q, expected a number or auto", c.String(_flagConcurrency)), 1)
+				}
+				options.Concurrency = n
+			}
+			options.KeepOrder = c.Bool(_flagKeepOrder)
+			options.ReorderWindow = c.Int(_flagReorderWindow)
+			if options.KeepOrder && options.StreamOutput {
+				return cli.Exit("--keep-order and --stream-output can't be used together", 1)
+			}
+			if rateBy := c.String(_flagRPSBy); rateBy != "" {
+				var err error
+				if options.RateBy, err = parseRateBy(rateBy); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				options.RateBy.MaxKeys = c.Int(_flagRPSByMaxKeys)
+				if options.RateBy.MaxKeys < 1 {
+					return cli.Exit(fmt.Sprintf("invalid --%s %d, expected at least 1", _flagRPSByMaxKeys, options.RateBy.MaxKeys), 1)
+				}
+				options.RateBy.MaxPending = c.Int(_flagRPSByMaxPending)
+				if options.RateBy.MaxPending < 1 {
+					return cli.Exit(fmt.Sprintf("invalid --%s %d, expected at least 1", _flagRPSByMaxPending, options.RateBy.MaxPending), 1)
+				}
+			}
+			options.SerializeBy = c.String(_flagSerializeBy)
+			options.MaxPendingKeys = c.Int(_flagMaxPendingKeys)
+			if options.MaxPendingKeys < 1 {
+				return cli.Exit(fmt.Sprintf("invalid --%s %d, expected at least 1", _flagMaxPendingKeys, options.MaxPendingKeys), 1)
 			}
-			input := io.ReadCloser(os.Stdin)
-			if path := c.String(_flagInputFile); path != "" {
-				f, err := openInputFile(path)
+			if options.ReorderWindow < 1 {
+				return cli.Exit(fmt.Sprintf("invalid --%s %d, expected at least 1", _flagReorderWindow, options.ReorderWindow), 1)
+			}
+			options.PauseOnRetryAfter = c.Bool(_flagPauseRetryAfter)
+			options.Breaker = streamexec.BreakerOptions{
+				Failures:     c.Int(_flagBreakerFailures),
+				FailureRatio: c.Float64(_flagBreakerRatio),
+				Window:       c.Duration(_flagBreakerWindow),
+				Cooldown:     c.Duration(_flagBreakerCooldown),
+			}
+			if r := options.Breaker.FailureRatio; r < 0 || r > 1 {
+				return cli.Exit(fmt.Sprintf("invalid --%s %v, expected between 0 and 1", _flagBreakerRatio, r), 1)
+			}
+			options.RetryOn = streamexec.RetryPolicy{
+				OnExit:    c.IntSlice(_flagRetryOnExit),
+				NotOnExit: c.IntSlice(_flagNoRetryOnExit),
+			}
+			if pattern := c.String(_flagRetryOnOutput); pattern != "" {
+				re, err := regexp.Compile(pattern)
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("invalid --%s: %v", _flagRetryOnOutput, err), 1)
+				}
+				options.RetryOn.OnOutput = re
+			}
+			switch options.Backoff.Jitter {
+			case streamexec.JitterFull, streamexec.JitterDecorrelated, streamexec.JitterNone:
+			default:
+				return cli.Exit(fmt.Sprintf("unknown retry jitter %q, expected full, decorrelated or none", options.Backoff.Jitter), 1)
+			}
+			switch options.TextOutput {
+			case streamexec.TextOutputInterleaved, streamexec.TextOutputStdout:
+			default:
+				return cli.Exit(fmt.Sprintf("unknown text output %q, expected interleaved or stdout", options.TextOutput), 1)
+			}
+			if c.Bool(_flagArgv) {
+				if c.String(_flagExecCmd) != "" {
+					return cli.Exit("--exec and --argv can't be used together", 1)
+				}
+				if c.IsSet(_flagShell) {
+					return cli.Exit("--shell and --argv can't be used together", 1)
+				}
+				argv := c.Args().Slice()
+				if err := streamexec.ValidateArgv(argv); err != nil {
+					return cli.Exit(fmt.Sprintf("invalid --argv command: %v", err), 1)
+				}
+				options.Params.Argv = argv
+				options.Params.Shell = ""
+			} else if c.String(_flagExecCmd) == "" {
+				return cli.Exit(`Required flag "exec" not set`, 1)
+			} else if c.NArg() > 0 {
+				return cli.Exit(fmt.Sprintf("unexpected arguments %q, did you mean to use --argv?", c.Args().Slice()), 1)
+			}
+
+			stdin, err := parseStdinOptions(c.String(_flagStdin))
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			options.Stdin = stdin
+			options.NoRecordEnv = c.Bool(_flagNoEnv)
+
+			options.Batch = streamexec.BatchOptions{
+				Size:    c.Int(_flagBatchSize),
+				Timeout: c.Duration(_flagBatchTimeout),
+				Input:   c.String(_flagBatchInput),
+			}
+			if options.Batch.Size < 1 {
+				return cli.Exit(fmt.Sprintf("invalid --%s %d, expected at least 1", _flagBatchSize, options.Batch.Size), 1)
+			}
+			switch options.Batch.Input {
+			case streamexec.BatchInputStdin, streamexec.BatchInputFile, streamexec.BatchInputEnv:
+			default:
+				return cli.Exit(fmt.Sprintf("unknown batch input %q, expected stdin, file or env", options.Batch.Input), 1)
+			}
+			if options.Batch.Size > 1 {
+				// these all work on a record's values, which a batch doesn't have
+				for _, flag := range []string{_flagSerializeBy, _flagRPSBy, _flagArgv} {
+					if c.IsSet(flag) {
+						return cli.Exit(fmt.Sprintf("--%s and --%s can't be used together", flag, _flagBatchSize), 1)
+					}
+				}
+			}
+			if options.Batch.Size > 1 && c.IsSet(_flagStdin) {
+				return cli.Exit(fmt.Sprintf("--%s can't be used with --%s, see --%s", _flagStdin, _flagBatchSize, _flagBatchInput), 1)
+			}
+
+			format := c.String(_flagInputFormat)
+			var inputFmt inputFormat
+			switch format {
+			case _inputFormatJSON:
+			case _inputFormatCSV, _inputFormatTSV:
+				opts, err := newCSVOptions(format, c.String(_flagDelimiter), c.Bool(_flagLazyQuotes), c.Bool(_flagNoHeader))
+				if err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				inputFmt.csv = &opts
+			case _inputFormatLines:
+				lineVar := c.String(_flagLineVar)
+				if lineVar == "" {
+					return cli.Exit("--line-var must not be empty", 1)
+				}
+				inputFmt.lines = &streamexec.LinesOptions{
+					Var:           lineVar,
+					NullDelimited: c.Bool(_flagNullDelimited),
+				}
+			default:
+				return cli.Exit(fmt.Sprintf("unknown input format %q, expected json, csv, tsv or lines", format), 1)
+			}
+
+			var source streamexec.Source
+			if patterns := c.StringSlice(_flagInputFile); len(patterns) > 0 {
+				paths, err := expandInputFiles(patterns)
 				if err != nil {
 					return cli.Exit(fmt.Sprintf("cannot open input file: %v", err), 1)
 				}
-				input = f
+				follow := c.Bool(_flagFollow)
+				if follow && len(paths) != 1 {
+					return cli.Exit("--follow requires exactly one input file", 1)
+				}
+				var inputs []streamexec.NamedSource
+				for _, path := range paths {
+					inputs = append(inputs, streamexec.NamedSource{
+						Name: path,
+						Open: func() (streamexec.Source, error) {
+							open := openInputFile
+							if follow {
+								open = streamexec.NewFollowReader
+							}
+							f, err := open(path)
+							if err != nil {
+								return nil, err
+							}
+							// a followed file may well be empty so far, and
+							// checking for an array would block until it isn't
+							return openSource(f, inputFmt, !follow)
+						},
+					})
+				}
+				source = streamexec.NewMultiSource(inputs)
+			} else {
+				if c.Bool(_flagFollow) {
+					return cli.Exit("--follow requires --input-json-file", 1)
+				}
+				var err error
+				source, err = openSource(os.Stdin, inputFmt, false)
+				if err != nil {
+					return cli.Exit(fmt.Sprintf("cannot read input: %v", err), 1)
+				}
 			}
-			ex := streamexec.New(input, os.Stdout, os.Stderr, options)
+			ex := streamexec.NewWithSource(source, os.Stdout, os.Stderr, options)
 			return ex.Run()
 		},
 	}
@@ -150,7 +651,7 @@ func cmdList() *cli.Command {
 			}
 
 			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-			fmt.Fprintln(w, "PID\tRUNNING\tDONE\tFAILED\tIN-FLIGHT\tCONCURRENCY\tEXEC")
+			fmt.Fprintln(w, "PID\tRUNNING\tDONE\tFAILED\tIN-FLIGHT\tCONCURRENCY\tBREAKER\tEXEC")
 			for _, sock := range sockets {
 				resp, err := streamexec.QuerySocket(sock, _ipcCmdStatus)
 				if err != nil {
@@ -166,8 +667,8 @@ func cmdList() *cli.Command {
 					execStr = execStr[:47] + "..."
 				}
 				running := time.Since(st.StartTime).Round(time.Second).String()
-				fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%s\n",
-					st.PID, running, st.Processed, st.Failed, st.InFlight, st.Concurrency, execStr)
+				fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+					st.PID, running, st.Processed, st.Failed, st.InFlight, st.Concurrency, breakerState(st.Breaker), execStr)
 			}
 			w.Flush()
 			return nil
@@ -269,65 +770,150 @@ func listSockets() []string {
 	return sockets
 }
 
-// openInputFile opens path and returns a ReadCloser that emits JSON lines.
-// If the file begins with '[' it is treated as a JSON array and each element
-// is streamed as an individual JSON line; otherwise the file is returned as-is
-// (JSON lines format).
-func openInputFile(path string) (io.ReadCloser, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
+// inputFormat selects a non-JSON Source; when neither is set input is JSON.
+type inputFormat struct {
+	csv   *streamexec.CSVOptions
+	lines *streamexec.LinesOptions
+}
+
+// openSource wraps input in the Source for its format. JSON input is read
+// as JSON lines, except that files beginning with '[' are streamed as a
+// top-level JSON array.
+func openSource(input io.ReadCloser, format inputFormat, sniffArray bool) (streamexec.Source, error) {
+	if format.csv != nil {
+		return streamexec.NewCSVSource(input, *format.csv), nil
+	}
+	if format.lines != nil {
+		return streamexec.NewLinesSource(input, *format.lines), nil
+	}
+	if !sniffArray {
+		return streamexec.NewJSONLinesSource(input), nil
 	}
 
-	br := bufio.NewReader(f)
+	br := bufio.NewReader(input)
+	rc := struct {
+		io.Reader
+		io.Closer
+	}{br, input}
 
 	// Peek past leading whitespace to detect array vs JSON lines.
-	var first byte
 	for {
 		b, err := br.ReadByte()
+		if err == io.EOF {
+			return streamexec.NewJSONLinesSource(rc), nil
+		}
 		if err != nil {
-			f.Close()
+			input.Close()
 			return nil, fmt.Errorf("reading input file: %w", err)
 		}
 		if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
-			first = b
 			br.UnreadByte()
-			break
+			if b == '[' {
+				return streamexec.NewJSONArraySource(rc), nil
+			}
+			return streamexec.NewJSONLinesSource(rc), nil
+		}
+	}
+}
+
+// expandInputFiles resolves glob patterns into the list of files to read,
+// in the order given. Each pattern must match at least one file.
+func expandInputFiles(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
 		}
+		if len(matches) == 0 {
+			// not a glob, or one that matched nothing; either way report
+			// it the same as a missing file
+			if _, err := os.Stat(pattern); err != nil {
+				return nil, err
+			}
+			matches = []string{pattern}
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// openInputFile opens path, transparently decompressing gzip, zstd, bzip2
+// and xz files.
+func openInputFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return streamexec.NewDecompressingReader(f)
+}
+
+func parseStdinOptions(mode string) (streamexec.StdinOptions, error) {
+	switch {
+	case mode == "none":
+		return streamexec.StdinOptions{}, nil
+	case mode == "record":
+		return streamexec.StdinOptions{Record: true}, nil
+	case strings.HasPrefix(mode, "field:") && len(mode) > len("field:"):
+		return streamexec.StdinOptions{Field: strings.TrimPrefix(mode, "field:")}, nil
+	default:
+		return streamexec.StdinOptions{}, fmt.Errorf("invalid --stdin %q, expected none, record or field:<name>", mode)
+	}
+}
+
+// parseRateBy parses --rps-by's "<field>=<rate>", or "<field>:host=<rate>"
+// to limit by the host of a URL in the field
+func parseRateBy(s string) (streamexec.RateByOptions, error) {
+	field, rps, ok := cutLast(s, "=")
+	if !ok || field == "" {
+		return streamexec.RateByOptions{}, fmt.Errorf("invalid --%s %q, expected <field>=<rate>", _flagRPSBy, s)
+	}
+	var o streamexec.RateByOptions
+	o.Field, o.Host = strings.CutSuffix(field, ":host")
+	var err error
+	if o.RPS, err = strconv.ParseFloat(rps, 64); err != nil || o.RPS <= 0 {
+		return streamexec.RateByOptions{}, fmt.Errorf("invalid --%s rate %q, expected a number above 0", _flagRPSBy, rps)
 	}
+	return o, nil
+}
 
-	if first != '[' {
-		// JSON lines — return the buffered reader; closing closes the file.
-		return struct {
-			io.Reader
-			io.Closer
-		}{br, f}, nil
+// cutLast is strings.Cut around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
 	}
+	return s, "", false
+}
 
-	// JSON array — stream each element as a JSON line through a pipe so we
-	// never load the whole array into memory.
-	pr, pw := io.Pipe()
-	go func() {
-		defer f.Close()
-		dec := json.NewDecoder(br)
-		if _, err := dec.Token(); err != nil { // consume '['
-			pw.CloseWithError(fmt.Errorf("parsing JSON array: %w", err))
-			return
+func newCSVOptions(format, delimiter string, lazyQuotes, noHeader bool) (streamexec.CSVOptions, error) {
+	opts := streamexec.CSVOptions{
+		Delimiter:  ',',
+		LazyQuotes: lazyQuotes,
+		NoHeader:   noHeader,
+	}
+	if format == _inputFormatTSV {
+		opts.Delimiter = '\t'
+	}
+	if delimiter != "" {
+		if delimiter == `\t` {
+			delimiter = "\t"
 		}
-		enc := json.NewEncoder(pw)
-		enc.SetEscapeHTML(false)
-		for dec.More() {
-			var elem json.RawMessage
-			if err := dec.Decode(&elem); err != nil {
-				pw.CloseWithError(fmt.Errorf("parsing JSON array element: %w", err))
-				return
-			}
-			if err := enc.Encode(elem); err != nil {
-				pw.CloseWithError(err)
-				return
-			}
+		r := []rune(delimiter)
+		if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+			return opts, fmt.Errorf("invalid delimiter %q, expected a single character", delimiter)
 		}
-		pw.Close()
-	}()
-	return pr, nil
+		opts.Delimiter = r[0]
+	}
+	return opts, nil
+}
+
+// breakerState describes the circuit breaker for 'stream-exec list'
+func breakerState(b *streamexec.BreakerStatus) string {
+	if b == nil {
+		return "-"
+	}
+	if b.State == streamexec.BreakerOpen {
+		return fmt.Sprintf("open (%s)", time.Until(b.OpenUntil).Round(time.Second))
+	}
+	return b.State
 }
@@ -0,0 +1,77 @@
+package streamexec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// expandArgv substitutes {field} placeholders in each element of argv with
+// the field's value from data. Each element stays a single argument however
+// the value is spaced or quoted, since no shell is involved. "{{" and "}}"
+// stand for literal braces.
+func expandArgv(argv []string, data map[string]interface{}, exactNumbers bool) ([]string, error) {
+	out := make([]string, len(argv))
+	for i, arg := range argv {
+		expanded, err := expandArg(arg, func(field string) (string, error) {
+			val, ok := data[field]
+			if !ok {
+				return "", fmt.Errorf("no field %q in record", field)
+			}
+			return convert(val, exactNumbers), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		out[i] = expanded
+	}
+	return out, nil
+}
+
+// ValidateArgv checks that the placeholders in argv are well formed, so a
+// bad template is caught before any records are read.
+func ValidateArgv(argv []string) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("argv is empty")
+	}
+	for _, arg := range argv {
+		if _, err := expandArg(arg, func(string) (string, error) { return "", nil }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandArg(arg string, lookup func(field string) (string, error)) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case c == '{' && strings.HasPrefix(arg[i:], "{{"):
+			sb.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(arg[i:], "}}"):
+			sb.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(arg[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed placeholder in %q", arg)
+			}
+			field := arg[i+1 : i+end]
+			if field == "" {
+				return "", fmt.Errorf("empty placeholder in %q", arg)
+			}
+			val, err := lookup(field)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(val)
+			i += end
+		case c == '}':
+			return "", fmt.Errorf("unmatched '}' in %q, use '}}' for a literal brace", arg)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,68 @@
+package streamexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandArgv(t *testing.T) {
+	data := map[string]interface{}{
+		"city": "New York",
+		"n":    2,
+		"obj":  map[string]interface{}{"a": "b"},
+		"nil":  nil,
+	}
+	input := map[string]struct {
+		argv        []string
+		expected    []string
+		expectedErr string
+	}{
+		"no placeholders": {
+			argv:     []string{"echo", "hi"},
+			expected: []string{"echo", "hi"},
+		},
+		"values stay a single argument": {
+			argv:     []string{"curl", "https://example.com/{city}?n={n}"},
+			expected: []string{"curl", "https://example.com/New York?n=2"},
+		},
+		"objects become JSON and null is empty": {
+			argv:     []string{"{obj}", "[{nil}]"},
+			expected: []string{`{"a":"b"}`, "[]"},
+		},
+		"escaped braces": {
+			argv:     []string{`{{"city": "{city}"}}`},
+			expected: []string{`{"city": "New York"}`},
+		},
+		"missing field": {
+			argv:        []string{"echo", "{nope}"},
+			expectedErr: `no field "nope" in record`,
+		},
+		"unclosed placeholder": {
+			argv:        []string{"echo", "{city"},
+			expectedErr: `unclosed placeholder in "{city"`,
+		},
+		"unmatched closing brace": {
+			argv:        []string{"echo", "city}"},
+			expectedErr: `unmatched '}' in "city}", use '}}' for a literal brace`,
+		},
+	}
+
+	for name, td := range input {
+		t.Run(name, func(t *testing.T) {
+			res, err := expandArgv(td.argv, data, false)
+			if td.expectedErr != "" {
+				assert.EqualError(t, err, td.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, td.expected, res)
+		})
+	}
+}
+
+func TestValidateArgv(t *testing.T) {
+	assert.NoError(t, ValidateArgv([]string{"echo", "{a}", "{{b}}"}))
+	assert.Error(t, ValidateArgv([]string{"echo", "{}"}))
+	assert.Error(t, ValidateArgv(nil))
+}
@@ -0,0 +1,182 @@
+package streamexec
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultMinConcurrency = 1
+const defaultMaxConcurrency = 100
+const defaultAutoscaleInterval = 5 * time.Second
+
+// concurrency is cut when more than this fraction of commands fail...
+const autoscaleMaxFailureRate = 0.05
+
+// ...or when p95 latency exceeds the baseline by this factor
+const autoscaleLatencyTolerance = 2.0
+
+// how many recent decisions are kept for the IPC status
+const autoscaleHistory = 10
+
+// AutoConcurrencyOptions sets concurrency automatically, using additive
+// increase/multiplicative decrease: it goes up by one each interval while
+// commands are succeeding and staying fast, and is halved when they start
+// failing or slowing down.
+type AutoConcurrencyOptions struct {
+	Enabled  bool
+	Min      int           // defaults to 1; also where it starts
+	Max      int           // defaults to 100
+	Interval time.Duration // how often it's adjusted; defaults to 5s
+}
+
+// ConcurrencyDecision records one adjustment, or decision not to adjust,
+// made by the controller
+type ConcurrencyDecision struct {
+	Time        time.Time     `json:"time"`
+	From        int           `json:"from"`
+	To          int           `json:"to"`
+	Reason      string        `json:"reason"`
+	Completed   int           `json:"completed"`
+	FailureRate float64       `json:"failure_rate"`
+	P95         time.Duration `json:"p95"`
+}
+
+// AutoConcurrencyStatus is the controller's state, as reported over IPC
+type AutoConcurrencyStatus struct {
+	Min       int                   `json:"min"`
+	Max       int                   `json:"max"`
+	Target    int                   `json:"target"`
+	Baseline  time.Duration         `json:"baseline_p95"`
+	Decisions []ConcurrencyDecision `json:"decisions"` // most recent last
+}
+
+// concurrencyController collects the outcome of each command and
+// periodically decides what concurrency should be
+type concurrencyController struct {
+	opts AutoConcurrencyOptions
+
+	mu        sync.Mutex
+	target    int
+	latencies []time.Duration // of commands completed since the last decision
+	failures  int
+	baseline  time.Duration // what p95 latency looks like when things are healthy
+	decisions []ConcurrencyDecision
+}
+
+func newConcurrencyController(o AutoConcurrencyOptions) *concurrencyController {
+	return &concurrencyController{opts: o, target: o.Min}
+}
+
+func (c *concurrencyController) observe(latency time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latencies = append(c.latencies, latency)
+	if !ok {
+		c.failures++
+	}
+}
+
+// observedLatency is how long the command itself took on its last attempt,
+// leaving out retry backoff and pauses, which say nothing about how loaded
+// whatever it's calling is. Without any attempts it's the elapsed time.
+func observedLatency(res *Result, elapsed time.Duration) time.Duration {
+	if res != nil && len(res.Attempts) > 0 {
+		return res.Attempts[len(res.Attempts)-1].Duration
+	}
+	return elapsed
+}
+
+// setTarget takes account of concurrency being set by hand
+func (c *concurrencyController) setTarget(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.target = n
+}
+
+// decide works out the new concurrency from what's completed since it was
+// last called
+func (c *concurrencyController) decide(now time.Time) ConcurrencyDecision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := ConcurrencyDecision{Time: now, From: c.target, To: c.target, Completed: len(c.latencies)}
+	defer func() {
+		c.target = d.To
+		c.latencies, c.failures = c.latencies[:0], 0
+		c.decisions = append(c.decisions, d)
+		if len(c.decisions) > autoscaleHistory {
+			c.decisions = slices.Delete(c.decisions, 0, len(c.decisions)-autoscaleHistory)
+		}
+	}()
+
+	if len(c.latencies) == 0 {
+		d.Reason = "nothing completed"
+		return d
+	}
+	d.FailureRate = float64(c.failures) / float64(len(c.latencies))
+	d.P95 = percentile(c.latencies, 0.95)
+
+	switch {
+	case d.FailureRate > autoscaleMaxFailureRate:
+		d.To = max(c.opts.Min, c.target/2)
+		d.Reason = fmt.Sprintf("failure rate %.0f%% is over %.0f%%", 100*d.FailureRate, 100*autoscaleMaxFailureRate)
+	case c.baseline > 0 && float64(d.P95) > autoscaleLatencyTolerance*float64(c.baseline):
+		d.To = max(c.opts.Min, c.target/2)
+		d.Reason = fmt.Sprintf("p95 latency %v is over %gx the baseline %v", d.P95.Round(time.Millisecond),
+			autoscaleLatencyTolerance, c.baseline.Round(time.Millisecond))
+	case c.target < c.opts.Max:
+		d.To = c.target + 1
+		d.Reason = "healthy"
+	default:
+		d.Reason = "healthy, at max"
+	}
+
+	// the baseline follows latency down straight away, but only creeps up,
+	// so it can adapt to commands getting slower for reasons other than load
+	// without masking a sudden slowdown
+	if c.baseline == 0 || d.P95 < c.baseline {
+		c.baseline = d.P95
+	} else if d.FailureRate <= autoscaleMaxFailureRate {
+		c.baseline += (d.P95 - c.baseline) / 10
+	}
+	return d
+}
+
+func (c *concurrencyController) status() AutoConcurrencyStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return AutoConcurrencyStatus{
+		Min:       c.opts.Min,
+		Max:       c.opts.Max,
+		Target:    c.target,
+		Baseline:  c.baseline,
+		Decisions: slices.Clone(c.decisions),
+	}
+}
+
+// percentile returns the p'th percentile of latencies, reordering them
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	slices.Sort(latencies)
+	i := int(float64(len(latencies))*p+0.5) - 1
+	return latencies[min(max(i, 0), len(latencies)-1)]
+}
+
+// autoscale adjusts concurrency every interval until ctx is done
+func (s *StreamExec) autoscale(ctx context.Context) {
+	ticker := time.NewTicker(s.options.AutoConcurrency.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d := s.autoscaler.decide(now)
+			if d.To != d.From {
+				s.SetConcurrency(d.To)
+			}
+			s.debugPrint(fmt.Sprintf("concurrency %d -> %d: %s", d.From, d.To, d.Reason))
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package streamexec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type observation struct {
+	latency time.Duration
+	ok      bool
+}
+
+func observations(n int, latency time.Duration, failures int) []observation {
+	var out []observation
+	for i := 0; i < n; i++ {
+		out = append(out, observation{latency: latency, ok: i >= failures})
+	}
+	return out
+}
+
+func TestConcurrencyController(t *testing.T) {
+	tests := map[string]struct {
+		opts AutoConcurrencyOptions
+		// each element is what completes in one interval
+		intervals [][]observation
+		expected  []int
+	}{
+		"increases by one while healthy": {
+			opts:      AutoConcurrencyOptions{Min: 1, Max: 10},
+			intervals: [][]observation{observations(10, time.Second, 0), observations(10, time.Second, 0), observations(10, time.Second, 0)},
+			expected:  []int{2, 3, 4},
+		},
+		"stops at max": {
+			opts:      AutoConcurrencyOptions{Min: 1, Max: 2},
+			intervals: [][]observation{observations(10, time.Second, 0), observations(10, time.Second, 0)},
+			expected:  []int{2, 2},
+		},
+		"holds when nothing completes": {
+			opts:      AutoConcurrencyOptions{Min: 1, Max: 10},
+			intervals: [][]observation{nil, nil},
+			expected:  []int{1, 1},
+		},
+		"halves on failures": {
+			opts: AutoConcurrencyOptions{Min: 1, Max: 10},
+			intervals: [][]observation{
+				observations(10, time.Second, 0), observations(10, time.Second, 0), observations(10, time.Second, 0),
+				observations(10, time.Second, 0), observations(10, time.Second, 0), observations(10, time.Second, 0),
+				observations(10, time.Second, 1),
+			},
+			expected: []int{2, 3, 4, 5, 6, 7, 3},
+		},
+		"doesn't go below min": {
+			opts:      AutoConcurrencyOptions{Min: 2, Max: 10},
+			intervals: [][]observation{observations(10, time.Second, 5), observations(10, time.Second, 5)},
+			expected:  []int{2, 2},
+		},
+		"halves when latency rises": {
+			opts: AutoConcurrencyOptions{Min: 1, Max: 10},
+			intervals: [][]observation{
+				observations(10, time.Second, 0), observations(10, time.Second, 0), observations(10, time.Second, 0),
+				observations(10, 3*time.Second, 0),
+			},
+			expected: []int{2, 3, 4, 2},
+		},
+		"tolerates small latency rises": {
+			opts: AutoConcurrencyOptions{Min: 1, Max: 10},
+			intervals: [][]observation{
+				observations(10, time.Second, 0), observations(10, 1500*time.Millisecond, 0),
+			},
+			expected: []int{2, 3},
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := newConcurrencyController(td.opts)
+			var got []int
+			for _, interval := range td.intervals {
+				for _, o := range interval {
+					c.observe(o.latency, o.ok)
+				}
+				got = append(got, c.decide(time.Now()).To)
+			}
+			assert.Equal(t, td.expected, got)
+		})
+	}
+}
+
+func TestConcurrencyControllerStatus(t *testing.T) {
+	c := newConcurrencyController(AutoConcurrencyOptions{Min: 1, Max: 10})
+	for i := 0; i < 3*autoscaleHistory; i++ {
+		c.observe(time.Second, true)
+		c.decide(time.Now())
+	}
+	c.setTarget(4)
+	st := c.status()
+	assert.Equal(t, 4, st.Target)
+	assert.Equal(t, time.Second, st.Baseline)
+	assert.Len(t, st.Decisions, autoscaleHistory)
+	assert.Equal(t, "healthy, at max", st.Decisions[len(st.Decisions)-1].Reason)
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 95*time.Millisecond, percentile(latencies, 0.95))
+	assert.Equal(t, 50*time.Millisecond, percentile(latencies, 0.5))
+	assert.Equal(t, time.Second, percentile([]time.Duration{time.Second}, 0.95))
+}
+
+// backoff between attempts isn't counted as the command being slow
+func TestObservedLatency(t *testing.T) {
+	res := &Result{
+		Duration: time.Minute,
+		Attempts: []Attempt{{Duration: time.Second}, {Duration: 2 * time.Second}},
+	}
+	assert.Equal(t, 2*time.Second, observedLatency(res, time.Minute))
+	assert.Equal(t, time.Minute, observedLatency(&Result{}, time.Minute))
+	assert.Equal(t, time.Minute, observedLatency(nil, time.Minute))
+}
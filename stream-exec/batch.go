@@ -0,0 +1,120 @@
+package streamexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// How the records in a batch are passed to the command
+const (
+	BatchInputStdin = "stdin" // as a JSON array on stdin
+	BatchInputFile  = "file"  // as a JSON array in a temporary file, whose path is in $SE_BATCH_FILE
+	BatchInputEnv   = "env"   // as envvars suffixed with each record's position in the batch: $user_0, $user_1...
+)
+
+// BatchOptions runs one command for each group of Size records, rather
+// than one per record. Batching is off unless Size is more than 1.
+type BatchOptions struct {
+	Size    int
+	Timeout time.Duration // run a partial batch once its first record is this old; 0 = wait until it's full
+	Input   string        // one of the BatchInput constants; defaults to BatchInputStdin
+}
+
+func (o BatchOptions) enabled() bool {
+	return o.Size > 1
+}
+
+// batchRecords groups the records from in into batches, each sent on to
+// out as a single record
+func (s *StreamExec) batchRecords(ctx context.Context, in <-chan Record, out chan<- Record) {
+	defer s.readWG.Done()
+	defer close(out)
+
+	var batch []Record
+	var timeout <-chan time.Time
+	var index int64
+	send := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		rec := newBatchRecord(index, batch)
+		index++
+		batch, timeout = nil, nil
+		select {
+		case out <- rec:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	for {
+		select {
+		case rec, ok := <-in:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) == 1 && s.options.Batch.Timeout > 0 {
+				timeout = time.After(s.options.Batch.Timeout)
+			}
+			if len(batch) >= s.options.Batch.Size && !send() {
+				return
+			}
+		case <-timeout:
+			if !send() {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newBatchRecord makes a record standing for the batch as a whole. Its Raw
+// is a JSON array of the records, and its Index counts batches.
+func newBatchRecord(index int64, batch []Record) Record {
+	var raw bytes.Buffer
+	raw.WriteByte('[')
+	for i, rec := range batch {
+		if i > 0 {
+			raw.WriteByte(',')
+		}
+		if rec.IsJSON {
+			raw.Write(rec.Raw)
+		} else {
+			// e.g. plain text lines, which may happen to be valid JSON
+			// too, but aren't the record
+			b, _ := json.Marshal(rec.Data)
+			raw.Write(b)
+		}
+	}
+	raw.WriteByte(']')
+	return Record{Raw: raw.Bytes(), Index: index, Batch: batch}
+}
+
+// batchEnvvars passes each record's values as envvars suffixed with its
+// position in the batch
+func batchEnvvars(batch []Record, o Options) []string {
+	var out []string
+	for i, rec := range batch {
+		for _, kv := range formatEnvvars(rec.Data, o) {
+			k, v, _ := strings.Cut(kv, "=")
+			out = append(out, fmt.Sprintf("%s_%d=%s", k, i, v))
+		}
+	}
+	return out
+}
+
+// batchIndices lists the input positions of the records in a batch
+func batchIndices(batch []Record) []int64 {
+	out := make([]int64, len(batch))
+	for i, rec := range batch {
+		out[i] = rec.Index
+	}
+	return out
+}
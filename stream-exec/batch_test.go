@@ -0,0 +1,102 @@
+package streamexec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchRecords(t *testing.T) {
+	tests := map[string]struct {
+		size     int
+		records  int
+		expected [][]int64
+	}{
+		"exact batches": {
+			size:     2,
+			records:  4,
+			expected: [][]int64{{0, 1}, {2, 3}},
+		},
+		"partial last batch": {
+			size:     3,
+			records:  4,
+			expected: [][]int64{{0, 1, 2}, {3}},
+		},
+		"fewer records than a batch": {
+			size:     10,
+			records:  2,
+			expected: [][]int64{{0, 1}},
+		},
+		"no records": {
+			size:    2,
+			records: 0,
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &StreamExec{
+				options:  Options{Batch: BatchOptions{Size: td.size}},
+				incoming: make(chan Record, td.records),
+			}
+			in := make(chan Record, td.records)
+			for i := 0; i < td.records; i++ {
+				in <- Record{Raw: []byte(`{}`), Index: int64(i)}
+			}
+			close(in)
+			s.readWG.Add(1)
+			s.batchRecords(context.Background(), in, s.incoming)
+
+			var got [][]int64
+			var index int64
+			for rec := range s.incoming {
+				assert.Equal(t, index, rec.Index)
+				index++
+				got = append(got, batchIndices(rec.Batch))
+			}
+			assert.Equal(t, td.expected, got)
+		})
+	}
+}
+
+func TestBatchRecordsTimeout(t *testing.T) {
+	s := &StreamExec{
+		options:  Options{Batch: BatchOptions{Size: 10, Timeout: 50 * time.Millisecond}},
+		incoming: make(chan Record, 1),
+	}
+	in := make(chan Record)
+	s.readWG.Add(1)
+	go s.batchRecords(context.Background(), in, s.incoming)
+	in <- Record{Raw: []byte(`{}`)}
+
+	select {
+	case rec := <-s.incoming:
+		assert.Len(t, rec.Batch, 1)
+	case <-time.After(time.Second):
+		t.Fatal("partial batch wasn't sent after the timeout")
+	}
+	close(in)
+	_, ok := <-s.incoming
+	assert.False(t, ok)
+}
+
+func TestNewBatchRecord(t *testing.T) {
+	rec := newBatchRecord(3, []Record{
+		{Raw: []byte(`{"a":1}`), IsJSON: true, Index: 6},
+		{Raw: []byte(`plain text`), Data: map[string]interface{}{"line": "plain text"}, Index: 7},
+		{Raw: []byte(`12345`), Data: map[string]interface{}{"line": "12345"}, Index: 8},
+	})
+	assert.Equal(t, int64(3), rec.Index)
+	assert.JSONEq(t, `[{"a":1},{"line":"plain text"},{"line":"12345"}]`, string(rec.Raw))
+	assert.Equal(t, []int64{6, 7, 8}, batchIndices(rec.Batch))
+}
+
+func TestBatchEnvvars(t *testing.T) {
+	envvars := batchEnvvars([]Record{
+		{Data: map[string]interface{}{"user": "alice", "n": 1}},
+		{Data: map[string]interface{}{"user": "bob=b"}},
+	}, Options{})
+	require.ElementsMatch(t, []string{"user_0=alice", "n_0=1", "user_1=bob=b"}, envvars)
+}
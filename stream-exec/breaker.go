@@ -0,0 +1,181 @@
+package streamexec
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // commands run as normal
+	BreakerOpen     = "open"      // too many failures; nothing's started until the cooldown's over
+	BreakerHalfOpen = "half-open" // a single probe command is running to see if things have recovered
+)
+
+const defaultBreakerWindow = time.Minute
+const defaultBreakerCooldown = 30 * time.Second
+
+// a failure ratio isn't acted on until the window has at least this many results
+const minBreakerSamples = 10
+
+// BreakerOptions configures a circuit breaker that stops commands being
+// started when too many are failing, e.g. because something they depend on
+// is down. It's disabled unless Failures or FailureRatio is set.
+type BreakerOptions struct {
+	Failures     int           // trip after this many failures within Window
+	FailureRatio float64       // or when this fraction of the results within Window are failures
+	Window       time.Duration // defaults to 1m
+	Cooldown     time.Duration // how long to pause once tripped, before probing; defaults to 30s
+}
+
+func (o BreakerOptions) enabled() bool {
+	return o.Failures > 0 || o.FailureRatio > 0
+}
+
+// BreakerStatus is the circuit breaker's state, as reported over IPC
+type BreakerStatus struct {
+	State     string    `json:"state"`
+	Trips     int64     `json:"trips"`
+	OpenUntil time.Time `json:"open_until,omitzero"`
+}
+
+type breakerResult struct {
+	at time.Time
+	ok bool
+}
+
+// circuitBreaker is shared by all the workers. Each calls acquire before
+// starting a command, and done with the outcome once it's finished.
+type circuitBreaker struct {
+	opts BreakerOptions
+
+	mu        sync.Mutex
+	state     string
+	results   []breakerResult // within the window, oldest first
+	openUntil time.Time
+	probing   bool
+	trips     int64
+	changed   chan struct{} // closed and replaced whenever the state changes
+}
+
+func newCircuitBreaker(o BreakerOptions) *circuitBreaker {
+	return &circuitBreaker{opts: o, state: BreakerClosed, changed: make(chan struct{})}
+}
+
+// acquire blocks until a command may be started. probe is true if the
+// command is being run to test whether the breaker can close again, in
+// which case it's the only one running until done is called.
+func (b *circuitBreaker) acquire(ctx context.Context) (probe bool, err error) {
+	for {
+		b.mu.Lock()
+		var wait <-chan time.Time
+		switch b.state {
+		case BreakerClosed:
+			b.mu.Unlock()
+			return false, nil
+		case BreakerOpen:
+			if !time.Now().Before(b.openUntil) {
+				b.setState(BreakerHalfOpen)
+				b.probing = true
+				b.mu.Unlock()
+				return true, nil
+			}
+			wait = time.After(time.Until(b.openUntil))
+		case BreakerHalfOpen:
+			if !b.probing {
+				b.probing = true
+				b.mu.Unlock()
+				return true, nil
+			}
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-wait:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// done records the outcome of a command started after acquire. It returns
+// the breaker's new state if the outcome changed it, or "" if not.
+func (b *circuitBreaker) done(probe bool, ok bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if probe {
+		b.probing = false
+		if ok {
+			b.results = b.results[:0]
+			b.setState(BreakerClosed)
+		} else {
+			b.trip(now)
+		}
+		return b.state
+	}
+	if b.state != BreakerClosed {
+		// started before the breaker tripped
+		return ""
+	}
+	b.results = append(b.results, breakerResult{at: now, ok: ok})
+	b.expire(now)
+	if !b.tripped() {
+		return ""
+	}
+	b.trip(now)
+	return b.state
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := BreakerStatus{State: b.state, Trips: b.trips}
+	if b.state == BreakerOpen {
+		st.OpenUntil = b.openUntil
+	}
+	return st
+}
+
+func (b *circuitBreaker) tripped() bool {
+	failures := 0
+	for _, r := range b.results {
+		if !r.ok {
+			failures++
+		}
+	}
+	if b.opts.Failures > 0 && failures >= b.opts.Failures {
+		return true
+	}
+	return b.opts.FailureRatio > 0 && len(b.results) >= minBreakerSamples &&
+		float64(failures)/float64(len(b.results)) >= b.opts.FailureRatio
+}
+
+func (b *circuitBreaker) trip(now time.Time) {
+	b.trips++
+	b.openUntil = now.Add(b.opts.Cooldown)
+	b.results = b.results[:0]
+	b.setState(BreakerOpen)
+}
+
+// expire drops results that have fallen out of the window
+func (b *circuitBreaker) expire(now time.Time) {
+	i := 0
+	for i < len(b.results) && now.Sub(b.results[i].at) > b.opts.Window {
+		i++
+	}
+	b.results = append(b.results[:0], b.results[i:]...)
+}
+
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	b.notify()
+}
+
+func (b *circuitBreaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,119 @@
+package streamexec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerTrips(t *testing.T) {
+	tests := map[string]struct {
+		opts     BreakerOptions
+		results  []bool
+		expected string
+	}{
+		"failures under the threshold": {
+			opts:     BreakerOptions{Failures: 3},
+			results:  []bool{false, true, false, true},
+			expected: BreakerClosed,
+		},
+		"failures reach the threshold": {
+			opts:     BreakerOptions{Failures: 3},
+			results:  []bool{false, true, false, true, false},
+			expected: BreakerOpen,
+		},
+		"ratio reached": {
+			opts:     BreakerOptions{FailureRatio: 0.5},
+			results:  []bool{true, false, true, false, true, false, true, false, true, false},
+			expected: BreakerOpen,
+		},
+		"ratio not reached": {
+			opts:     BreakerOptions{FailureRatio: 0.5},
+			results:  []bool{true, false, true, false, true, true, true, false, true, false},
+			expected: BreakerClosed,
+		},
+		"ratio needs enough results": {
+			opts:     BreakerOptions{FailureRatio: 0.5},
+			results:  []bool{false, false, false},
+			expected: BreakerClosed,
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			td.opts.Window, td.opts.Cooldown = time.Minute, time.Minute
+			b := newCircuitBreaker(td.opts)
+			for _, ok := range td.results {
+				probe, err := b.acquire(context.Background())
+				require.NoError(t, err)
+				require.False(t, probe)
+				b.done(probe, ok)
+			}
+			assert.Equal(t, td.expected, b.status().State)
+		})
+	}
+}
+
+// failures that have dropped out of the window don't count
+func TestBreakerWindow(t *testing.T) {
+	b := newCircuitBreaker(BreakerOptions{Failures: 2, Window: 20 * time.Millisecond, Cooldown: time.Minute})
+	b.done(false, false)
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, "", b.done(false, false))
+	assert.Equal(t, BreakerClosed, b.status().State)
+	assert.Equal(t, BreakerOpen, b.done(false, false))
+}
+
+func TestBreakerProbe(t *testing.T) {
+	b := newCircuitBreaker(BreakerOptions{Failures: 1, Window: time.Minute, Cooldown: 50 * time.Millisecond})
+	assert.Equal(t, BreakerOpen, b.done(false, false))
+	st := b.status()
+	assert.Equal(t, int64(1), st.Trips)
+	assert.False(t, st.OpenUntil.IsZero())
+
+	// nothing starts until the cooldown's over, then just the probe
+	start := time.Now()
+	probe, err := b.acquire(context.Background())
+	require.NoError(t, err)
+	assert.True(t, probe)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, b.status().State)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = b.acquire(ctx)
+	assert.Error(t, err, "only the probe runs while half open")
+
+	// a failed probe opens the breaker again
+	assert.Equal(t, BreakerOpen, b.done(true, false))
+	assert.Equal(t, int64(2), b.status().Trips)
+
+	probe, err = b.acquire(context.Background())
+	require.NoError(t, err)
+	require.True(t, probe)
+
+	// others waiting are let through once the probe succeeds
+	released := make(chan bool)
+	go func() {
+		p, err := b.acquire(context.Background())
+		assert.NoError(t, err)
+		released <- p
+	}()
+	assert.Equal(t, BreakerClosed, b.done(true, true))
+	select {
+	case p := <-released:
+		assert.False(t, p)
+	case <-time.After(time.Second):
+		t.Fatal("waiting worker wasn't released")
+	}
+}
+
+// results from commands started before the breaker tripped are ignored
+func TestBreakerIgnoresStragglers(t *testing.T) {
+	b := newCircuitBreaker(BreakerOptions{Failures: 1, Window: time.Minute, Cooldown: time.Minute})
+	b.done(false, false)
+	assert.Equal(t, "", b.done(false, false))
+	assert.Equal(t, int64(1), b.status().Trips)
+}
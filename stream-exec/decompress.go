@@ -0,0 +1,95 @@
+package streamexec
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte("BZh")
+	// after bzip2Magic and the block size digit, the first block, or the end
+	// of the stream if it's empty
+	bzip2BlockMagic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59} // "1AY&SY"
+	bzip2EndMagic   = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+	xzMagic         = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// NewDecompressingReader sniffs the magic bytes at the start of r and, if
+// they match gzip, zstd, bzip2 or xz, returns a reader that decompresses the
+// stream as it's read. Anything else is returned unchanged. Closing the
+// returned reader closes r.
+func NewDecompressingReader(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(bzip2Magic) + 1 + len(bzip2BlockMagic))
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		r.Close()
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		// multistream is on by default, so concatenated .gz files (e.g.
+		// from `cat a.gz b.gz`) are read in full
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("opening gzip stream: %w", err)
+		}
+		return readCloser{gz, func() error {
+			gz.Close()
+			return r.Close()
+		}}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("opening zstd stream: %w", err)
+		}
+		return readCloser{zr, func() error {
+			zr.Close()
+			return r.Close()
+		}}, nil
+	case isBzip2(magic):
+		return readCloser{bzip2.NewReader(br), r.Close}, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("opening xz stream: %w", err)
+		}
+		return readCloser{xr, r.Close}, nil
+	default:
+		return readCloser{br, r.Close}, nil
+	}
+}
+
+// isBzip2 checks the whole bzip2 header, as "BZh" alone is plausible text
+func isBzip2(magic []byte) bool {
+	n := len(bzip2Magic)
+	if len(magic) < n+1+len(bzip2BlockMagic) || !bytes.HasPrefix(magic, bzip2Magic) {
+		return false
+	}
+	if level := magic[n]; level < '1' || level > '9' {
+		return false
+	}
+	block := magic[n+1:]
+	return bytes.HasPrefix(block, bzip2BlockMagic) || bytes.HasPrefix(block, bzip2EndMagic)
+}
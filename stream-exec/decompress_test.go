@@ -0,0 +1,91 @@
+package streamexec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+const decompressPayload = "{\"a\":\"b\"}\n"
+
+// decompressPayload compressed with bzip2 -9; the stdlib has no bzip2 writer
+var bzip2Payload = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x51, 0x79,
+	0x43, 0x50, 0x00, 0x00, 0x04, 0x59, 0x80, 0x00, 0x10, 0x10, 0x00, 0x00,
+	0x10, 0x30, 0x00, 0x00, 0x0a, 0x20, 0x00, 0x31, 0x0c, 0x01, 0x00, 0x7e,
+	0xa8, 0xd7, 0x12, 0xaf, 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x51, 0x79,
+	0x43, 0x50,
+}
+
+func compressWith(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := newWriter(&buf)
+	require.NoError(t, err)
+	_, err = w.Write([]byte(decompressPayload))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDecompressingReader(t *testing.T) {
+	input := map[string][]byte{
+		"plain": []byte(decompressPayload),
+		"gzip": compressWith(t, func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		}),
+		"zstd": compressWith(t, func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}),
+		"xz": compressWith(t, func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		}),
+		"bzip2": bzip2Payload,
+	}
+
+	for name, data := range input {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewDecompressingReader(io.NopCloser(bytes.NewReader(data)))
+			require.NoError(t, err)
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, decompressPayload, string(out))
+			assert.NoError(t, r.Close())
+		})
+	}
+}
+
+// input shorter than the longest magic number must still be passed through
+func TestDecompressingReaderShortInput(t *testing.T) {
+	r, err := NewDecompressingReader(io.NopCloser(bytes.NewReader([]byte("{}"))))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(out))
+}
+
+// text that happens to start with "BZh" isn't mistaken for bzip2
+func TestDecompressingReaderBZhText(t *testing.T) {
+	for _, text := range []string{"BZh", "BZhello world\n", "BZh9 not a block\n"} {
+		r, err := NewDecompressingReader(io.NopCloser(bytes.NewReader([]byte(text))))
+		require.NoError(t, err)
+		out, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, text, string(out))
+	}
+}
+
+func TestDecompressingReaderEmptyBzip2(t *testing.T) {
+	empty := append([]byte("BZh9"), 0x17, 0x72, 0x45, 0x38, 0x50, 0x90, 0x00, 0x00, 0x00, 0x00)
+	r, err := NewDecompressingReader(io.NopCloser(bytes.NewReader(empty)))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
@@ -1,77 +1,193 @@
 package streamexec
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 )
 
-func (s *StreamExec) exec(ctx context.Context, envvars []string) *Result {
+func (s *StreamExec) exec(ctx context.Context, rec Record, worker int) *Result {
+	var envvars []string
+	switch {
+	case s.options.NoRecordEnv:
+	case rec.Batch == nil:
+		envvars = formatEnvvars(rec.Data, s.options)
+	case s.options.Batch.Input == BatchInputEnv:
+		envvars = batchEnvvars(rec.Batch, s.options)
+	}
+	envvars = append(envvars, recordMetadata(rec, s.options, worker)...)
+	stdin := stdinFor(rec, s.options.Stdin)
+
+	res := &Result{
+		Envvars:    envvars,
+		Params:     s.options.Params,
+		SourceFile: rec.Source,
+		Line:       rec.Line,
+		Index:      rec.Index,
+	}
+	if rec.Batch != nil {
+		res.Batch = batchIndices(rec.Batch)
+	}
+
+	var name string
+	var args []string
+	if len(s.options.Params.Argv) > 0 {
+		argv, err := expandArgv(s.options.Params.Argv, rec.Data, s.options.ExactNumbers)
+		if err != nil {
+			res.Stderr = err.Error()
+			return res
+		}
+		name, args = argv[0], argv[1:]
+	} else {
+		shell := strings.Fields(s.options.Params.Shell)
+		name, args = shell[0], append(shell[1:], s.options.Params.ExecString)
+	}
+
+	if rec.Batch != nil && s.options.Batch.Input == BatchInputFile && !s.options.DryRun {
+		path, err := writeBatchFile(rec)
+		if err != nil {
+			res.Stderr = err.Error()
+			return res
+		}
+		defer os.Remove(path)
+		envvars = append(envvars, fmt.Sprintf("%sBATCH_FILE=%s", s.options.MetaPrefix, path))
+		res.Envvars = envvars
+	}
 
 	if s.options.DryRun {
-		log.Printf("Dry-run: bash -c '%s'\n", s.options.Params.ExecString)
+		if len(s.options.Params.Argv) > 0 {
+			log.Printf("Dry-run: %q\n", append([]string{name}, args...))
+		} else {
+			log.Printf("Dry-run: %s '%s'\n", s.options.Params.Shell, s.options.Params.ExecString)
+		}
 		log.Printf("with envvars: %v", envvars)
 		return nil
 	}
 
-	stdout, err := execWithRetries(s.options.Params.Retries, func() ([]byte, error) {
-		cmd := exec.CommandContext(ctx, "bash", "-c", s.options.Params.ExecString)
+	start := time.Now()
+	err := execWithRetries(ctx, s.options.Params.Retries, func(attempt int) error {
+		if err := s.waitWhilePaused(ctx); err != nil {
+			return err
+		}
+		attemptStart := time.Now()
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.options.Params.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, s.options.Params.Timeout)
+		}
+		defer cancel()
+
+		cmd := exec.CommandContext(attemptCtx, name, args...)
+		if s.options.Params.Timeout > 0 {
+			// without a timeout the command shares stream-exec's process
+			// group, so Ctrl-C at the terminal reaches it directly
+			waited := killProcessGroup(cmd, s.options.KillGrace)
+			defer waited()
+		}
 		cmd.Env = append(os.Environ(), envvars...)
-		return cmd.CombinedOutput()
-	}, s.debugPrint,
-		time.Second) // todo, make this configurable
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%sATTEMPT=%d", s.options.MetaPrefix, attempt))
+		if stdin != nil {
+			cmd.Stdin = bytes.NewReader(stdin)
+		}
+		output := s.newAttemptOutput(rec, envvars)
+		output.attach(cmd)
+		err := cmd.Run()
+		if outputErr := output.finish(res); err == nil {
+			err = outputErr
+		}
+		res.TimedOut = err != nil && ctx.Err() == nil && attemptCtx.Err() == context.DeadlineExceeded
+		res.ExitCode = exitCode(err)
+		res.Attempts = append(res.Attempts, Attempt{
+			ExitCode: res.ExitCode,
+			Duration: time.Since(attemptStart),
+			TimedOut: res.TimedOut,
+		})
+		if err != nil && output.retryAfter.found {
+			after := min(output.retryAfter.after, s.options.Backoff.MaxDelay)
+			res.Attempts[len(res.Attempts)-1].RetryAfter = after
+			if s.options.PauseOnRetryAfter && s.options.RetryOn.retryable(res) {
+				s.pause(time.Now().Add(after))
+			}
+			err = &retryAfterError{after: after, err: err}
+		}
+		return err
+	}, func(error) bool {
+		return s.options.RetryOn.retryable(res)
+	}, s.debugPrint, newBackoff(s.options.Backoff))
+	res.Duration = time.Since(start)
 
 	if err != nil {
 		var e *exec.ExitError
-		if errors.As(err, &e) {
-			code := e.ProcessState.ExitCode()
-			return &Result{
-				Envvars:   envvars,
-				Params:    s.options.Params,
-				Stderr:    string(e.Stderr),
-				Stdout:    string(stdout),
-				ExitCode:  code,
-				Succeeded: false,
-			}
-		} else {
-			return &Result{
-				Envvars:   envvars,
-				Params:    s.options.Params,
-				Stderr:    fmt.Sprintf("%v", err),
-				Stdout:    string(stdout),
-				Succeeded: false,
-			}
+		if res.TimedOut {
+			res.Stderr += fmt.Sprintf("timed out after %v\n", s.options.Params.Timeout)
+		} else if !errors.As(err, &e) {
+			res.Stderr += fmt.Sprintf("%v\n", err)
 		}
+		return res
+	}
+	res.Succeeded = true
+	return res
+}
+
+// writeBatchFile writes the batch out as a JSON array for BatchInputFile,
+// returning the file's path
+func writeBatchFile(rec Record) (string, error) {
+	f, err := os.CreateTemp("", "stream-exec-batch-*.json")
+	if err != nil {
+		return "", fmt.Errorf("can't create batch file: %w", err)
 	}
-	return &Result{
-		Envvars:   envvars,
-		Params:    s.options.Params,
-		Stdout:    string(stdout),
-		ExitCode:  0,
-		Succeeded: true,
+	_, err = f.Write(append(rec.Raw, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("can't write batch file: %w", err)
 	}
+	return f.Name(), nil
 }
 
-// simple retry mechanism with exponential backoff
-func execWithRetries(retries int, f func() ([]byte, error), debugPrintFn func(string), sleepTime time.Duration) ([]byte, error) {
-	retryLen := 0
-	var lastStdout []byte
-	var lastErr error
-	for i := 0; i <= retries; i++ {
-		res, err := f()
-		lastErr = err
-		lastStdout = res
-		if err == nil {
-			// we're done, complete
-			return res, nil
+// exitCode is the exit code of a command that returned err, or -1 if it
+// didn't exit normally
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e *exec.ExitError
+	if errors.As(err, &e) {
+		return e.ProcessState.ExitCode()
+	}
+	return -1
+}
+
+// checkShell makes sure the interpreter for --shell can be found
+func checkShell(shell string) error {
+	fields := strings.Fields(shell)
+	if len(fields) == 0 {
+		return fmt.Errorf("no shell given")
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return fmt.Errorf("shell %q is not available: %w", shell, err)
+	}
+	return nil
+}
+
+// stdinFor returns what to write to the command's stdin, or nil for nothing
+func stdinFor(rec Record, o StdinOptions) []byte {
+	if o.Record {
+		return append(append([]byte{}, rec.Raw...), '\n')
+	}
+	if o.Field != "" {
+		val, ok := rec.Data[o.Field]
+		if !ok {
+			return []byte{}
 		}
-		debugPrintFn(fmt.Sprintf("retry attempt %d", i))
-		time.Sleep(1 + time.Duration(retryLen)*sleepTime)
-		retryLen = retryLen * retryLen
+		return []byte(convert(val, true))
 	}
-	return lastStdout, lastErr
+	return nil
 }
@@ -0,0 +1,29 @@
+package streamexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStdinFor(t *testing.T) {
+	rec := Record{
+		Data: map[string]interface{}{"body": "line 1\nline 2", "obj": map[string]interface{}{"a": 1}},
+		Raw:  []byte(`{"body": "..."}`),
+	}
+	input := map[string]struct {
+		opts     StdinOptions
+		expected []byte
+	}{
+		"none":          {opts: StdinOptions{}, expected: nil},
+		"record":        {opts: StdinOptions{Record: true}, expected: []byte("{\"body\": \"...\"}\n")},
+		"string field":  {opts: StdinOptions{Field: "body"}, expected: []byte("line 1\nline 2")},
+		"object field":  {opts: StdinOptions{Field: "obj"}, expected: []byte(`{"a":1}`)},
+		"missing field": {opts: StdinOptions{Field: "nope"}, expected: []byte{}},
+	}
+	for name, td := range input {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, td.expected, stdinFor(rec, td.opts))
+		})
+	}
+}
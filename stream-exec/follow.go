@@ -0,0 +1,123 @@
+package streamexec
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultFollowInterval = 250 * time.Millisecond
+
+type followReader struct {
+	path     string
+	interval time.Duration
+
+	mu     sync.Mutex
+	f      *os.File
+	offset int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewFollowReader reads path like `tail -F`: rather than stopping at the
+// end of the file it waits for more to be appended. If the file is truncated
+// it's read again from the start, and if it's replaced (e.g. by log
+// rotation) the new file is opened once the old one has been read to the
+// end. Read only returns io.EOF once the reader has been closed.
+func NewFollowReader(path string) (io.ReadCloser, error) {
+	return newFollowReader(path, defaultFollowInterval)
+}
+
+func newFollowReader(path string, interval time.Duration) (*followReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &followReader{
+		path:     path,
+		interval: interval,
+		f:        f,
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-r.closed:
+			return 0, io.EOF
+		default:
+		}
+
+		r.mu.Lock()
+		n, err := r.f.Read(p)
+		r.offset += int64(n)
+		r.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil && err != io.EOF {
+			select {
+			case <-r.closed:
+				return 0, io.EOF
+			default:
+				return 0, err
+			}
+		}
+
+		if r.reopenIfChanged() {
+			continue
+		}
+		select {
+		case <-r.closed:
+			return 0, io.EOF
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// reopenIfChanged checks, at the end of the current file, whether it has
+// been truncated or replaced and repositions accordingly. It returns true
+// if there may be more to read straight away.
+func (r *followReader) reopenIfChanged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.f.Stat()
+	if err != nil {
+		return false
+	}
+	latest, err := os.Stat(r.path)
+	if err != nil {
+		// mid-rotation, the path may briefly not exist
+		return false
+	}
+
+	if !os.SameFile(current, latest) {
+		f, err := os.Open(r.path)
+		if err != nil {
+			return false
+		}
+		r.f.Close()
+		r.f = f
+		r.offset = 0
+		return true
+	}
+	if latest.Size() < r.offset {
+		if _, err := r.f.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+		r.offset = 0
+		return true
+	}
+	return false
+}
+
+func (r *followReader) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
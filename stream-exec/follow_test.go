@@ -0,0 +1,75 @@
+package streamexec
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendFile(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func readLine(t *testing.T, br *bufio.Reader) string {
+	t.Helper()
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := br.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a line")
+		return ""
+	}
+}
+
+func TestFollowReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.jsonl")
+	appendFile(t, path, "one\n")
+
+	r, err := newFollowReader(path, 10*time.Millisecond)
+	require.NoError(t, err)
+	br := bufio.NewReader(r)
+
+	assert.Equal(t, "one\n", readLine(t, br))
+
+	// appended after reaching the end
+	appendFile(t, path, "two\n")
+	assert.Equal(t, "two\n", readLine(t, br))
+
+	// truncated and rewritten
+	require.NoError(t, os.WriteFile(path, []byte("three\n"), 0644))
+	assert.Equal(t, "three\n", readLine(t, br))
+
+	// rotated: the old file is moved aside and a new one created
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendFile(t, path, "four\n")
+	assert.Equal(t, "four\n", readLine(t, br))
+
+	// closing unblocks a pending read
+	done := make(chan error, 1)
+	go func() {
+		_, err := br.ReadString('\n')
+		done <- err
+	}()
+	require.NoError(t, r.Close())
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("read did not return after close")
+	}
+}
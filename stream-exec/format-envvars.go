@@ -1,42 +1,121 @@
 package streamexec
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
 	"regexp"
+	"slices"
 )
 
 // apologies for the parochialism, non-ascii support would be preferred
 // but I have no idea what support they have as envvars
 var invalidEnvarKey = regexp.MustCompile("[^a-zA-Z0-9_]")
 
-func formatEnvString(incoming string) ([]string, error) {
+var integerLiteral = regexp.MustCompile("^-?[0-9]+$")
+
+// decodeRecord keeps numbers as json.Number so large integers such as
+// 64-bit IDs don't lose precision by going through float64
+func decodeRecord(incoming []byte) (map[string]interface{}, error) {
 	data := make(map[string]interface{})
-	err := json.Unmarshal([]byte(incoming), &data)
-	if err != nil {
+	dec := json.NewDecoder(bytes.NewReader(incoming))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
 		return nil, err
 	}
+	// More() would miss a stray closing } or ], so make sure there's
+	// nothing at all after the value
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return data, nil
+}
+
+// formatEnvvars passes the record's values as envvars. Where names clash,
+// the later variable wins, so flattened members come first and a key of
+// the record's own, like user_id alongside {"user":{"id":1}}, overrides
+// them. Keys are taken in order so that the same input always gives the
+// same environment.
+func formatEnvvars(data map[string]interface{}, o Options) []string {
 	var out []string
-	for k, v := range data {
-		out = append(out, fmt.Sprintf("%s=%v", invalidEnvarKey.ReplaceAllString(k, "_"), convert(v)))
+	keys := slices.Sorted(maps.Keys(data))
+	if o.Flatten.Enabled {
+		for _, k := range keys {
+			out = flattenEnvvars(out, k, data[k], o, 1)
+		}
 	}
-	return out, nil
+	for _, k := range keys {
+		out = append(out, envvar(k, data[k], o.ExactNumbers))
+	}
+	return out
 }
 
-func convert(val interface{}) string {
+func envvar(key string, val interface{}, exactNumbers bool) string {
+	return fmt.Sprintf("%s=%v", invalidEnvarKey.ReplaceAllString(key, "_"), convert(val, exactNumbers))
+}
+
+// flattenEnvvars adds a variable for each member of a nested object or
+// array, keyed by its path from the top-level key, so {"user":{"id":1}}
+// also gives user_id=1. Arrays also get a <key>_len count. Anything nested
+// deeper than MaxDepth is left as JSON in its parent's variable.
+func flattenEnvvars(out []string, prefix string, val interface{}, o Options, depth int) []string {
+	if o.Flatten.MaxDepth > 0 && depth > o.Flatten.MaxDepth {
+		return out
+	}
+	sep := o.Flatten.Separator
+	if sep == "" {
+		sep = defaultFlattenSeparator
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			child := v[k]
+			key := prefix + sep + k
+			out = append(out, envvar(key, child, o.ExactNumbers))
+			out = flattenEnvvars(out, key, child, o, depth+1)
+		}
+	case []interface{}:
+		out = append(out, envvar(prefix+sep+"len", len(v), o.ExactNumbers))
+		for i, child := range v {
+			key := fmt.Sprintf("%s%s%d", prefix, sep, i)
+			out = append(out, envvar(key, child, o.ExactNumbers))
+			out = flattenEnvvars(out, key, child, o, depth+1)
+		}
+	}
+	return out
+}
+
+func convert(val interface{}, exactNumbers bool) string {
 
 	switch v := val.(type) {
 	case nil:
 		return ""
+	case json.Number:
+		if exactNumbers {
+			// verbatim, exponents and trailing zeros included
+			return v.String()
+		}
+		if integerLiteral.MatchString(v.String()) {
+			// integers are passed through as written, however big
+			return v.String()
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		// otherwise formatted the same as a float64, so 2.0 is still 2
+		return convert(f, exactNumbers)
 	case int, int8, int16, int32, int64:
 		// probably will never work because json doesn't do ints
 		return fmt.Sprintf("%d", v)
 	case float32, float64:
 
 		// attempt to cast to int just in case
-		f, ok := v.(float64) 
+		f, ok := v.(float64)
 		if ok {
-			i := int(f)	
+			i := int(f)
 			if float64(i) == f {
 				// actually fine as an integer
 				return fmt.Sprintf("%d", i)
@@ -47,6 +126,9 @@ func convert(val interface{}) string {
 	case string:
 		return v
 	default:
+		if !exactNumbers {
+			v = normaliseNumbers(v)
+		}
 		d, err := json.Marshal(v)
 		if err != nil {
 			panic(err)
@@ -54,3 +136,56 @@ func convert(val interface{}) string {
 		return string(d)
 	}
 }
+
+// normaliseNumbers copies a nested value with its numbers formatted as
+// convert would format them on their own, so 1.50 is 1.5 however deeply
+// it's nested
+func normaliseNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		return json.Number(convert(v, false))
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, member := range v {
+			out[k] = normaliseNumbers(member)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, member := range v {
+			out[i] = normaliseNumbers(member)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// recordMetadata describes the record and the execution, as distinct from
+// the record's own values
+func recordMetadata(rec Record, o Options, worker int) []string {
+	prefix := o.MetaPrefix
+	out := []string{fmt.Sprintf("%sINDEX=%d", prefix, rec.Index)}
+	if rec.Batch != nil {
+		// the batch reaches the command on stdin or in a file; as a whole it
+		// could easily be too big for the environment, and it has no line
+		// or source file of its own
+		return append(out,
+			fmt.Sprintf("%sWORKER=%d", prefix, worker),
+			fmt.Sprintf("%sRUN_ID=%s", prefix, o.RunID),
+			fmt.Sprintf("%sBATCH_SIZE=%d", prefix, len(rec.Batch)),
+		)
+	}
+	out = append(out,
+		fmt.Sprintf("%sLINE=%d", prefix, rec.Line),
+		fmt.Sprintf("%sWORKER=%d", prefix, worker),
+		fmt.Sprintf("%sRUN_ID=%s", prefix, o.RunID),
+	)
+	if !o.NoRecordEnv {
+		out = append(out, fmt.Sprintf("%sRECORD=%s", prefix, rec.Raw))
+	}
+	if rec.Source != "" {
+		out = append(out, fmt.Sprintf("%sSOURCE_FILE=%s", prefix, rec.Source))
+	}
+	return out
+}
@@ -28,6 +28,28 @@ func TestFormattingEnvvarString(t *testing.T) {
 				"a=1652988784000",
 			},
 		},
+		"large integer precision": {
+			input: `{"id": 9007199254740993, "neg": -18446744073709551617}`,
+			expectedOutput: []string{
+				"id=9007199254740993",
+				"neg=-18446744073709551617",
+			},
+		},
+		"exponents and trailing zeros are normalised": {
+			input: `{"a": 1e3, "b": 1.50, "c": 2.0}`,
+			expectedOutput: []string{
+				"a=1000",
+				"b=1.5",
+				"c=2",
+			},
+		},
+		"nested numbers normalised the same way": {
+			input: `{"a": [9007199254740993, 1.50, 1e3], "b": {"c": {"d": 2.0}}}`,
+			expectedOutput: []string{
+				"a=[9007199254740993,1.5,1000]",
+				`b={"c":{"d":2}}`,
+			},
+		},
 		"float handling": {
 			input: `{"a": 1.5}`,
 			expectedOutput: []string{
@@ -45,7 +67,8 @@ func TestFormattingEnvvarString(t *testing.T) {
 
 	for name, td := range input {
 		t.Run(name, func(t *testing.T) {
-			res, err := formatEnvString(td.input)
+			data, err := decodeRecord([]byte(td.input))
+			res := formatEnvvars(data, Options{})
 			sort.Strings(td.expectedOutput)
 			sort.Strings(res)
 			assert.Equal(t, td.expectedOutput, res, name)
@@ -53,3 +76,123 @@ func TestFormattingEnvvarString(t *testing.T) {
 		})
 	}
 }
+
+func TestFormattingEnvvarStringFlattened(t *testing.T) {
+	input := map[string]struct {
+		input          string
+		flatten        FlattenOptions
+		expectedOutput []string
+	}{
+		"nested object": {
+			input:   `{"user": {"id": 1, "org": "x"}}`,
+			flatten: FlattenOptions{Enabled: true},
+			expectedOutput: []string{
+				`user={"id":1,"org":"x"}`,
+				"user_id=1",
+				"user_org=x",
+			},
+		},
+		"array with count": {
+			input:   `{"tags": ["a", "b"]}`,
+			flatten: FlattenOptions{Enabled: true},
+			expectedOutput: []string{
+				`tags=["a","b"]`,
+				"tags_len=2",
+				"tags_0=a",
+				"tags_1=b",
+			},
+		},
+		"custom separator and key normalisation": {
+			input:   `{"a-b": {"c.d": true}}`,
+			flatten: FlattenOptions{Enabled: true, Separator: "__"},
+			expectedOutput: []string{
+				`a_b={"c.d":true}`,
+				"a_b__c_d=true",
+			},
+		},
+		"max depth": {
+			input:   `{"a": {"b": {"c": 1}, "l": [[1]]}}`,
+			flatten: FlattenOptions{Enabled: true, MaxDepth: 1},
+			expectedOutput: []string{
+				`a={"b":{"c":1},"l":[[1]]}`,
+				`a_b={"c":1}`,
+				"a_l=[[1]]",
+			},
+		},
+		"disabled": {
+			input:          `{"a": {"b": 1}}`,
+			expectedOutput: []string{`a={"b":1}`},
+		},
+	}
+
+	for name, td := range input {
+		t.Run(name, func(t *testing.T) {
+			data, err := decodeRecord([]byte(td.input))
+			assert.NoError(t, err)
+			res := formatEnvvars(data, Options{Flatten: td.flatten})
+			sort.Strings(td.expectedOutput)
+			sort.Strings(res)
+			assert.Equal(t, td.expectedOutput, res, name)
+		})
+	}
+}
+
+// a key of the record's own wins over a flattened member with the same
+// name, whichever order the map gives them in
+func TestFormattingEnvvarStringFlattenedCollision(t *testing.T) {
+	data, err := decodeRecord([]byte(`{"user":{"id":1},"user_id":2}`))
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		res := formatEnvvars(data, Options{Flatten: FlattenOptions{Enabled: true}})
+		assert.Equal(t, []string{"user_id=1", `user={"id":1}`, "user_id=2"}, res)
+	}
+}
+
+func TestFormattingEnvvarStringExactNumbers(t *testing.T) {
+	data, err := decodeRecord([]byte(`{"a": 1e3, "b": 1.50, "c": 2.0, "d": 7, "e": [1.50, {"f": 1e3}]}`))
+	assert.NoError(t, err)
+	res := formatEnvvars(data, Options{ExactNumbers: true})
+	sort.Strings(res)
+	assert.Equal(t, []string{"a=1e3", "b=1.50", "c=2.0", "d=7", `e=[1.50,{"f":1e3}]`}, res)
+}
+
+func TestDecodeRecordTrailingData(t *testing.T) {
+	for _, input := range []string{`{"a": 1} {"b": 2}`, `{"a": 1}}`, `{"a": 1}]`, `{"a": 1} x`} {
+		_, err := decodeRecord([]byte(input))
+		assert.Error(t, err, input)
+	}
+	_, err := decodeRecord([]byte("{\"a\": 1} \n"))
+	assert.NoError(t, err)
+}
+
+func TestRecordMetadata(t *testing.T) {
+	rec := Record{
+		Raw:    []byte(`{"a": 1}`),
+		Line:   3,
+		Index:  2,
+		Source: "in.jsonl",
+	}
+	res := recordMetadata(rec, Options{MetaPrefix: "X_", RunID: "abc"}, 4)
+	sort.Strings(res)
+	assert.Equal(t, []string{
+		"X_INDEX=2",
+		"X_LINE=3",
+		`X_RECORD={"a": 1}`,
+		"X_RUN_ID=abc",
+		"X_SOURCE_FILE=in.jsonl",
+		"X_WORKER=4",
+	}, res)
+}
+
+// a batch's records aren't put in the environment
+func TestRecordMetadataBatch(t *testing.T) {
+	rec := newBatchRecord(1, []Record{{Raw: []byte(`{"a": 1}`), Line: 3}, {Raw: []byte(`{"a": 2}`), Line: 4}})
+	res := recordMetadata(rec, Options{MetaPrefix: "X_", RunID: "abc"}, 4)
+	sort.Strings(res)
+	assert.Equal(t, []string{
+		"X_BATCH_SIZE=2",
+		"X_INDEX=1",
+		"X_RUN_ID=abc",
+		"X_WORKER=4",
+	}, res)
+}
@@ -14,13 +14,16 @@ import (
 // StatusResponse is the payload returned by the IPC status command.
 // It is also used by the --list client to display running instances.
 type StatusResponse struct {
-	PID         int       `json:"pid"`
-	StartTime   time.Time `json:"start_time"`
-	ExecString  string    `json:"exec_string"`
-	Processed   int64     `json:"processed"`
-	Failed      int64     `json:"failed"`
-	InFlight    int64     `json:"in_flight"`
-	Concurrency int64     `json:"concurrency"`
+	PID             int                    `json:"pid"`
+	RunID           string                 `json:"run_id"`
+	StartTime       time.Time              `json:"start_time"`
+	ExecString      string                 `json:"exec_string"`
+	Processed       int64                  `json:"processed"`
+	Failed          int64                  `json:"failed"`
+	InFlight        int64                  `json:"in_flight"`
+	Concurrency     int64                  `json:"concurrency"`
+	Breaker         *BreakerStatus         `json:"breaker,omitempty"`          // nil if the circuit breaker isn't enabled
+	AutoConcurrency *AutoConcurrencyStatus `json:"auto_concurrency,omitempty"` // nil unless --concurrency auto
 }
 
 // IPCResponse is the envelope returned for every IPC request.
@@ -110,15 +113,25 @@ func writeIPCResponse(conn net.Conn, r IPCResponse) {
 }
 
 func (s *StreamExec) currentStatus() StatusResponse {
-	return StatusResponse{
+	st := StatusResponse{
 		PID:         os.Getpid(),
+		RunID:       s.options.RunID,
 		StartTime:   s.startTime,
-		ExecString:  s.options.Params.ExecString,
+		ExecString:  s.options.Params.Command(),
 		Processed:   atomic.LoadInt64(&s.processed),
 		Failed:      atomic.LoadInt64(&s.failed),
 		InFlight:    atomic.LoadInt64(&s.inFlight),
 		Concurrency: atomic.LoadInt64(&s.currentConcurrency),
 	}
+	if s.autoscaler != nil {
+		auto := s.autoscaler.status()
+		st.AutoConcurrency = &auto
+	}
+	if s.breaker != nil {
+		breaker := s.breaker.status()
+		st.Breaker = &breaker
+	}
+	return st
 }
 
 // QuerySocket sends a request to the socket at path and returns the response.
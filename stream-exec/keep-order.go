@@ -0,0 +1,92 @@
+package streamexec
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultReorderWindow = 1000
+
+// orderer holds back results that complete out of order, writing each once
+// everything before it in the input has been written. A record is only
+// started once it's within window records of the oldest one not yet
+// written, so one slow record holds up the rest rather than letting
+// finished results pile up.
+type orderer struct {
+	window int64
+	write  func(*Result)
+
+	mu       sync.Mutex
+	next     int64             // index of the oldest record not yet written
+	pending  map[int64]*Result // completed early; nil for records with nothing to write
+	advanced chan struct{}     // closed and replaced whenever next moves on
+}
+
+func newOrderer(window int, write func(*Result)) *orderer {
+	return &orderer{
+		window:   int64(window),
+		write:    write,
+		pending:  make(map[int64]*Result),
+		advanced: make(chan struct{}),
+	}
+}
+
+// wait blocks until the record at index is within the window
+func (o *orderer) wait(ctx context.Context, index int64) error {
+	for {
+		o.mu.Lock()
+		if index < o.next+o.window {
+			o.mu.Unlock()
+			return nil
+		}
+		advanced := o.advanced
+		o.mu.Unlock()
+
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// done takes the result for the record at index, which may be nil, and
+// writes out whatever's now next in line
+func (o *orderer) done(index int64, res *Result) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pending[index] = res
+	moved := false
+	for {
+		r, ok := o.pending[o.next]
+		if !ok {
+			break
+		}
+		delete(o.pending, o.next)
+		if r != nil {
+			o.write(r)
+		}
+		o.next++
+		moved = true
+	}
+	if moved {
+		close(o.advanced)
+		o.advanced = make(chan struct{})
+	}
+}
+
+// flush writes out everything still held back, in order, when stopping
+// before every record has completed
+func (o *orderer) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.pending) > 0 {
+		if r, ok := o.pending[o.next]; ok {
+			delete(o.pending, o.next)
+			if r != nil {
+				o.write(r)
+			}
+		}
+		o.next++
+	}
+}
@@ -0,0 +1,86 @@
+package streamexec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderer(t *testing.T) {
+	tests := map[string]struct {
+		completions []int64 // indices in the order they complete
+		skip        map[int64]bool
+		expected    []int64
+	}{
+		"in order": {
+			completions: []int64{0, 1, 2},
+			expected:    []int64{0, 1, 2},
+		},
+		"reversed": {
+			completions: []int64{2, 1, 0},
+			expected:    []int64{0, 1, 2},
+		},
+		"shuffled": {
+			completions: []int64{1, 3, 0, 4, 2},
+			expected:    []int64{0, 1, 2, 3, 4},
+		},
+		"nothing to write for some": {
+			completions: []int64{1, 0, 2},
+			skip:        map[int64]bool{1: true},
+			expected:    []int64{0, 2},
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			var written []int64
+			o := newOrderer(10, func(r *Result) { written = append(written, r.Index) })
+			for _, i := range td.completions {
+				if td.skip[i] {
+					o.done(i, nil)
+				} else {
+					o.done(i, &Result{Index: i})
+				}
+			}
+			assert.Equal(t, td.expected, written)
+		})
+	}
+}
+
+// records can't get more than the window ahead of the oldest unwritten one
+func TestOrdererWindow(t *testing.T) {
+	o := newOrderer(2, func(*Result) {})
+	require.NoError(t, o.wait(context.Background(), 0))
+	require.NoError(t, o.wait(context.Background(), 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, o.wait(ctx, 2), "record 2 is outside the window until 0 is written")
+
+	o.done(1, &Result{Index: 1})
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, o.wait(ctx, 2), "1 finishing doesn't help while 0 is still running")
+
+	released := make(chan error)
+	go func() { released <- o.wait(context.Background(), 2) }()
+	o.done(0, &Result{Index: 0})
+	select {
+	case err := <-released:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("record 2 wasn't released once 0 was written")
+	}
+}
+
+// results held back when stopping early are still written, in order
+func TestOrdererFlush(t *testing.T) {
+	var written []int64
+	o := newOrderer(10, func(r *Result) { written = append(written, r.Index) })
+	o.done(4, &Result{Index: 4})
+	o.done(2, &Result{Index: 2})
+	o.flush()
+	assert.Equal(t, []int64{2, 4}, written)
+}
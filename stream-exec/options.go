@@ -1,20 +1,78 @@
 package streamexec
 
+import (
+	"strings"
+	"time"
+)
+
 const defaultConcurrency = 10
 const defaultInputByteLen = 5000
+const defaultFlattenSeparator = "_"
+const defaultMetaPrefix = "SE_"
+const defaultShell = "bash -c"
+const defaultKillGrace = 5 * time.Second
 
 type Options struct {
 	OutputLog          string
 	IncomingBufferSize int
 	Concurrency        int
+	AutoConcurrency    AutoConcurrencyOptions // if enabled, Concurrency is ignored
+	Batch              BatchOptions
+	KeepOrder          bool   // write results in input order
+	ReorderWindow      int    // with KeepOrder, how far ahead of the oldest unwritten record work can get; defaults to 1000
+	SerializeBy        string // records with the same value for this field run one at a time, in input order
+	MaxPendingKeys     int    // with SerializeBy, how many keys can have records queued; defaults to 1000
 	ContinueOnErr      bool
 	DryRun             bool
 	DebugMode          bool
 	RPS                float64 // max executions per second; 0 = unlimited
+	RateBy             RateByOptions
+	Flatten            FlattenOptions
+	ExactNumbers       bool   // pass numbers through exactly as written in the input
+	MetaPrefix         string // prefix of the SE_INDEX, SE_RECORD etc. variables; defaults to "SE_"
+	RunID              string // exposed as SE_RUN_ID; generated if empty
+	Stdin              StdinOptions
+	NoRecordEnv        bool          // don't pass the record's values (or SE_RECORD) as envvars
+	KillGrace          time.Duration // how long a timed out command gets to exit after SIGTERM before SIGKILL
+	TextOutput         string        // TextOutputInterleaved (the default) or TextOutputStdout
+	StreamOutput       bool          // print output line by line as it's written, rather than once the command exits
+	StreamPrefix       string        // put in front of each streamed line, after expanding $variables
+	MaxOutputBytes     int64         // stdout and stderr beyond this are left out of the Result; 0 = unlimited
+	OutputDir          string        // also write each command's full stdout and stderr to files here
+	Backoff            BackoffOptions
+	RetryOn            RetryPolicy
+	Breaker            BreakerOptions
+	PauseOnRetryAfter  bool // when a command asks to be retried later, hold off starting any others until then too
 	Params             Params
 }
 
+// FlattenOptions controls whether nested objects and arrays in a record are
+// also exposed as individual variables.
+type FlattenOptions struct {
+	Enabled   bool
+	Separator string // joins the keys of nested values; defaults to "_"
+	MaxDepth  int    // levels of nesting to flatten; 0 = unlimited
+}
+
+// StdinOptions selects what's written to each command's stdin. By default
+// the command gets an empty stdin.
+type StdinOptions struct {
+	Record bool   // the record as it appeared in the input, newline terminated
+	Field  string // or just the value of this field, verbatim
+}
+
 type Params struct {
+	Shell      string `json:",omitempty"` // interpreter ExecString is appended to; defaults to "bash -c"
 	ExecString string
+	Argv       []string `json:",omitempty"` // run directly, without a shell, instead of ExecString
 	Retries    int
+	Timeout    time.Duration `json:",omitempty"` // per attempt; 0 = no limit
+}
+
+// Command describes what's being run, for display.
+func (p Params) Command() string {
+	if len(p.Argv) > 0 {
+		return strings.Join(p.Argv, " ")
+	}
+	return p.ExecString
 }
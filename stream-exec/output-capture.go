@@ -0,0 +1,189 @@
+package streamexec
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// defaultSpillMemoryBytes caps how much output is kept in memory for the
+// text output when it's also being written to files in OutputDir, if
+// MaxOutputBytes isn't set
+const defaultSpillMemoryBytes = 64 * 1024
+
+// attemptOutput sends the stdout and stderr of one attempt at a command to
+// wherever they're wanted: buffers for the Result, the text output as
+// they're written with StreamOutput, and files in OutputDir.
+type attemptOutput struct {
+	stdout, stderr *cappedBuffer // nil if not kept
+	interleaved    *cappedBuffer // nil unless needed for the text output
+	streamed       []*lineWriter
+	stdoutFile     *spillFile // nil without OutputDir
+	stderrFile     *spillFile
+	retryAfter     retryAfterScanner
+}
+
+func (s *StreamExec) newAttemptOutput(rec Record, envvars []string) *attemptOutput {
+	o := &attemptOutput{}
+	limit := s.options.MaxOutputBytes
+	if s.options.OutputDir != "" {
+		if limit == 0 {
+			limit = defaultSpillMemoryBytes
+		}
+		base := filepath.Join(s.options.OutputDir, fmt.Sprintf("%s-%d", s.options.RunID, rec.Index))
+		o.stdoutFile = newSpillFile(base + ".stdout")
+		o.stderrFile = newSpillFile(base + ".stderr")
+	}
+	if !s.options.StreamOutput || s.streams.structured.output != nil || s.options.RetryOn.OnOutput != nil {
+		// when streaming, output is only kept for the output log or to
+		// decide whether to retry
+		o.stdout, o.stderr = newCappedBuffer(limit), newCappedBuffer(limit)
+	}
+	switch {
+	case s.options.StreamOutput:
+		prefix := expandPrefix(s.options.StreamPrefix, envvars)
+		o.streamed = append(o.streamed, newLineWriter(&s.textMu, s.streams.text.output, prefix))
+		if s.options.TextOutput != TextOutputStdout {
+			o.streamed = append(o.streamed, newLineWriter(&s.textMu, s.streams.text.err, prefix))
+		}
+	case s.options.TextOutput != TextOutputStdout:
+		o.interleaved = newCappedBuffer(limit)
+	}
+	return o
+}
+
+// attach sets cmd's stdout and stderr
+func (o *attemptOutput) attach(cmd *exec.Cmd) {
+	var stdout, stderr []io.Writer
+	if o.stdout != nil {
+		stdout, stderr = append(stdout, o.stdout), append(stderr, o.stderr)
+	}
+	if o.interleaved != nil {
+		stdout, stderr = append(stdout, o.interleaved), append(stderr, o.interleaved)
+	}
+	if o.stdoutFile != nil {
+		stdout, stderr = append(stdout, o.stdoutFile), append(stderr, o.stderrFile)
+	}
+	if len(o.streamed) > 0 {
+		stdout = append(stdout, o.streamed[0])
+	}
+	if len(o.streamed) > 1 {
+		stderr = append(stderr, o.streamed[1])
+	}
+	stderr = append(stderr, &o.retryAfter)
+	cmd.Stdout, cmd.Stderr = io.MultiWriter(stdout...), io.MultiWriter(stderr...)
+}
+
+// finish flushes and closes everything once the command has exited, and
+// records what it wrote in res
+func (o *attemptOutput) finish(res *Result) error {
+	for _, w := range o.streamed {
+		w.Flush()
+	}
+	o.retryAfter.Flush()
+	res.Stdout, res.StdoutTruncated = o.stdout.String(), o.stdout.Dropped()
+	res.Stderr, res.StderrTruncated = o.stderr.String(), o.stderr.Dropped()
+	res.Output = o.interleaved.String()
+	res.StdoutFile, res.StderrFile = "", ""
+	var err error
+	if o.stdoutFile != nil {
+		res.StdoutFile, err = o.stdoutFile.finish()
+	}
+	if o.stderrFile != nil {
+		var stderrErr error
+		res.StderrFile, stderrErr = o.stderrFile.finish()
+		if err == nil {
+			err = stderrErr
+		}
+	}
+	return err
+}
+
+// cappedBuffer keeps the first limit bytes written to it (all of them if
+// limit is 0) and counts the rest. Writes never fail, so a command isn't
+// cut off for producing too much output. It's safe for concurrent use, as
+// the interleaved buffer is written to while copying both stdout and stderr.
+// A nil *cappedBuffer is empty.
+type cappedBuffer struct {
+	mu      sync.Mutex
+	limit   int64
+	buf     bytes.Buffer
+	dropped int64
+}
+
+func newCappedBuffer(limit int64) *cappedBuffer {
+	return &cappedBuffer{limit: limit}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	keep := int64(len(p))
+	if b.limit > 0 {
+		keep = min(keep, max(b.limit-int64(b.buf.Len()), 0))
+	}
+	b.buf.Write(p[:keep])
+	b.dropped += int64(len(p)) - keep
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Dropped is the number of bytes written past the limit
+func (b *cappedBuffer) Dropped() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// spillFile writes everything written to it to path. The file's only
+// created on the first write, so commands without any output don't leave
+// empty files behind; any left by an earlier attempt are removed.
+type spillFile struct {
+	path string
+	f    *os.File
+	err  error
+}
+
+func newSpillFile(path string) *spillFile {
+	os.Remove(path)
+	return &spillFile{path: path}
+}
+
+func (w *spillFile) Write(p []byte) (int, error) {
+	if w.f == nil && w.err == nil {
+		w.f, w.err = os.Create(w.path)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.f.Write(p)
+}
+
+// finish closes the file, returning its path if anything was written to it
+func (w *spillFile) finish() (string, error) {
+	if w.err != nil {
+		return "", fmt.Errorf("writing output to %s: %w", w.path, w.err)
+	}
+	if w.f == nil {
+		return "", nil
+	}
+	if err := w.f.Close(); err != nil {
+		return "", fmt.Errorf("writing output to %s: %w", w.path, err)
+	}
+	return w.path, nil
+}
@@ -0,0 +1,61 @@
+package streamexec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	tests := map[string]struct {
+		limit           int64
+		writes          []string
+		expected        string
+		expectedDropped int64
+	}{
+		"unlimited": {
+			writes:   []string{"hello ", "world"},
+			expected: "hello world",
+		},
+		"under the limit": {
+			limit:    20,
+			writes:   []string{"hello ", "world"},
+			expected: "hello world",
+		},
+		"exactly the limit": {
+			limit:    5,
+			writes:   []string{"hello"},
+			expected: "hello",
+		},
+		"cut part way through a write": {
+			limit:           8,
+			writes:          []string{"hello ", "world"},
+			expected:        "hello wo",
+			expectedDropped: 3,
+		},
+		"writes after the limit are counted": {
+			limit:           5,
+			writes:          []string{"hello", " ", "world"},
+			expected:        "hello",
+			expectedDropped: 6,
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := newCappedBuffer(td.limit)
+			for _, s := range td.writes {
+				n, err := b.Write([]byte(s))
+				assert.NoError(t, err)
+				assert.Equal(t, len(s), n)
+			}
+			assert.Equal(t, td.expected, b.String())
+			assert.Equal(t, td.expectedDropped, b.Dropped())
+		})
+	}
+}
+
+func TestCappedBufferNil(t *testing.T) {
+	var b *cappedBuffer
+	assert.Equal(t, "", b.String())
+	assert.Equal(t, int64(0), b.Dropped())
+}
@@ -0,0 +1,50 @@
+package streamexec
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// killProcessGroup runs cmd in its own process group so that when its
+// context is done the whole group is stopped, not just the immediate child:
+// otherwise anything the shell started (curl, sleep, ...) is left running as
+// an orphan. The group gets SIGTERM first, then SIGKILL if it's still around
+// after grace.
+//
+// The returned func must be called once cmd has been waited for. Once the
+// group's gone its id can be reused, so the pending SIGKILL is called off;
+// if the command exited but left stragglers behind, they're killed then.
+func killProcessGroup(cmd *exec.Cmd, grace time.Duration) (waited func()) {
+	var mu sync.Mutex
+	var pgid int
+	var timer *time.Timer
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		pgid = cmd.Process.Pid
+		timer = time.AfterFunc(grace, func() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		})
+		err := syscall.Kill(-pgid, syscall.SIGTERM)
+		if err == syscall.ESRCH {
+			return os.ErrProcessDone
+		}
+		return err
+	}
+	// stop waiting on output pipes held open by stragglers shortly after
+	// they've been sent SIGKILL
+	cmd.WaitDelay = grace + time.Second
+
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if timer != nil && timer.Stop() {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}
+}
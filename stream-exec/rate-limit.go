@@ -0,0 +1,262 @@
+package streamexec
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const defaultMaxRateLimiters = 10000
+const defaultMaxRatePending = 10000
+
+// RateByOptions limits how often commands run for each distinct value of
+// a field, on top of the overall RPS. Off unless both Field and RPS are set.
+type RateByOptions struct {
+	Field      string
+	Host       bool    // key on the host of the URL in Field, rather than its whole value
+	RPS        float64 // max executions per second for each value
+	MaxKeys    int     // how many values' limiters are kept; beyond this only idle ones are dropped. Defaults to 10000
+	MaxPending int     // how many records can be held back waiting for their value's limit; defaults to 10000
+}
+
+func (o RateByOptions) enabled() bool {
+	return o.Field != "" && o.RPS > 0
+}
+
+// keyedLimiter holds a rate limiter for each value of a field, created as
+// values are first seen. Once there are more than MaxKeys, the least
+// recently used are dropped if they're idle. A limiter is idle once its
+// bucket has refilled, when it's no different from a new one; those still
+// paying off a recent execution are kept, so dropping them can't let a
+// burst through.
+type keyedLimiter struct {
+	opts RateByOptions
+
+	mu       sync.Mutex
+	limiters map[string]*list.Element // of *keyLimiter
+	lru      *list.List               // most recently used at the front
+}
+
+type keyLimiter struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+// how many of the least recently used limiters are looked at for idle ones
+// to drop as each new one is added. Those further forward have been used
+// more recently, so are no more likely to be idle.
+const maxEvictionScan = 8
+
+func newKeyedLimiter(o RateByOptions) *keyedLimiter {
+	return &keyedLimiter{
+		opts:     o,
+		limiters: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// key is the value rec is limited by, and false if it doesn't have one,
+// in which case it isn't limited
+func (k *keyedLimiter) key(rec Record) (string, bool) {
+	val, ok := rec.Data[k.opts.Field]
+	if !ok {
+		return "", false
+	}
+	key := convert(val, true)
+	if k.opts.Host {
+		// values that aren't URLs are used as they are
+		if u, err := url.Parse(key); err == nil && u.Host != "" {
+			key = u.Hostname()
+		}
+	}
+	return key, true
+}
+
+// limiter returns the limiter for key, creating it if need be
+func (k *keyedLimiter) limiter(key string, now time.Time) *rate.Limiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if e, ok := k.limiters[key]; ok {
+		k.lru.MoveToFront(e)
+		return e.Value.(*keyLimiter).limiter
+	}
+	e := k.lru.Back()
+	for i := 0; i < maxEvictionScan && e != nil && k.lru.Len() >= k.opts.MaxKeys; i++ {
+		prev := e.Prev()
+		if l := e.Value.(*keyLimiter); l.limiter.TokensAt(now) >= float64(l.limiter.Burst()) {
+			k.lru.Remove(e)
+			delete(k.limiters, l.key)
+		}
+		e = prev
+	}
+	l := &keyLimiter{key: key, limiter: rate.NewLimiter(rate.Limit(k.opts.RPS), 1)}
+	k.limiters[key] = k.lru.PushFront(l)
+	return l.limiter
+}
+
+// allowedAt is when key is next allowed an execution, without using it up
+func (k *keyedLimiter) allowedAt(key string, now time.Time) time.Time {
+	tokens := k.limiter(key, now).TokensAt(now)
+	if tokens >= 1 {
+		return now
+	}
+	return now.Add(time.Duration((1 - tokens) / k.opts.RPS * float64(time.Second)))
+}
+
+// take uses up one of key's executions
+func (k *keyedLimiter) take(key string, now time.Time) {
+	k.limiter(key, now).ReserveN(now, 1)
+}
+
+// wait blocks until rec's value is allowed another execution, and uses it
+func (k *keyedLimiter) wait(ctx context.Context, rec Record) error {
+	key, ok := k.key(rec)
+	if !ok {
+		return nil
+	}
+	return k.limiter(key, time.Now()).Wait(ctx)
+}
+
+// rateLimitRecords passes records from in on to out as their value's limit
+// allows, holding back those that have to wait rather than having a worker
+// wait with them, so records for other values can carry on. out is
+// unbuffered, so a record is only passed on once a worker's free to run
+// it, and that's when its value's execution is used up: records can't
+// bunch up in a buffer while the workers are busy and then run back to
+// back. Once MaxPending records are held, reading from in waits.
+func (s *StreamExec) rateLimitRecords(ctx context.Context, in <-chan Record, out chan<- Record) {
+	defer s.readWG.Done()
+	defer close(out)
+
+	if s.orderer != nil && s.serializer == nil {
+		// as in serializeRecords, records wait for the reorder window in
+		// input order, before being held back. That's done apart from
+		// holding them, which has to carry on while the window is full.
+		in = s.orderRecords(ctx, in)
+	}
+
+	var (
+		held     int
+		unkeyed  []Record                // not limited, so ready as soon as there's a worker
+		queued   = map[string][]Record{} // by value, in input order
+		schedule keySchedule             // the values in queued, by when they're next allowed
+	)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for in != nil || held > 0 {
+		// the next record to pass on, if one's ready: the first in input
+		// order of those without a value and those whose value allows
+		var (
+			next  Record
+			keyed bool
+			send  chan<- Record
+			due   <-chan time.Time
+		)
+		now := time.Now()
+		keyReady := schedule.Len() > 0 && !schedule[0].at.After(now)
+		switch {
+		case len(unkeyed) > 0 && (!keyReady || unkeyed[0].Index < schedule[0].index):
+			next, send = unkeyed[0], out
+		case keyReady:
+			next, keyed, send = queued[schedule[0].key][0], true, out
+		}
+		if schedule.Len() > 0 && !keyReady {
+			timer.Reset(schedule[0].at.Sub(now))
+			due = timer.C
+		}
+		recv := in
+		if held >= s.options.RateBy.MaxPending {
+			recv = nil
+		}
+
+		select {
+		case send <- next:
+			held--
+			if !keyed {
+				unkeyed = unkeyed[1:]
+				break
+			}
+			// the execution's used up now a worker has the record
+			key := schedule[0].key
+			now := time.Now()
+			s.keyedLimiter.take(key, now)
+			if queued[key] = queued[key][1:]; len(queued[key]) == 0 {
+				delete(queued, key)
+				heap.Pop(&schedule)
+			} else {
+				schedule[0].at = s.keyedLimiter.allowedAt(key, now)
+				schedule[0].index = queued[key][0].Index
+				heap.Fix(&schedule, 0)
+			}
+		case rec, ok := <-recv:
+			if !ok {
+				in = nil
+				break
+			}
+			held++
+			key, limited := s.keyedLimiter.key(rec)
+			if !limited {
+				unkeyed = append(unkeyed, rec)
+				break
+			}
+			if _, ok := queued[key]; !ok {
+				heap.Push(&schedule, scheduledKey{key: key, at: s.keyedLimiter.allowedAt(key, time.Now()), index: rec.Index})
+			}
+			queued[key] = append(queued[key], rec)
+		case <-due:
+		case <-ctx.Done():
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// orderRecords passes records from in on to the channel it returns once
+// the reorder window allows
+func (s *StreamExec) orderRecords(ctx context.Context, in <-chan Record) <-chan Record {
+	out := make(chan Record)
+	go func() {
+		defer close(out)
+		for rec := range in {
+			if err := s.orderer.wait(ctx, rec.Index); err != nil {
+				return
+			}
+			select {
+			case out <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+type scheduledKey struct {
+	key   string
+	at    time.Time // when key is next allowed an execution
+	index int64     // of key's first queued record, to break ties in input order
+}
+
+// keySchedule is a heap of values with records waiting, soonest allowed first
+type keySchedule []scheduledKey
+
+func (h keySchedule) Len() int { return len(h) }
+func (h keySchedule) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].index < h[j].index
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h keySchedule) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *keySchedule) Push(x any)   { *h = append(*h, x.(scheduledKey)) }
+func (h *keySchedule) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
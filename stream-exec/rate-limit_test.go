@@ -0,0 +1,133 @@
+package streamexec
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyedLimiterKey(t *testing.T) {
+	tests := map[string]struct {
+		opts     RateByOptions
+		data     map[string]interface{}
+		expected string
+		limited  bool
+	}{
+		"value": {
+			opts:     RateByOptions{Field: "tenant"},
+			data:     map[string]interface{}{"tenant": "acme"},
+			expected: "acme",
+			limited:  true,
+		},
+		"number": {
+			opts:     RateByOptions{Field: "tenant"},
+			data:     map[string]interface{}{"tenant": float64(12)},
+			expected: "12",
+			limited:  true,
+		},
+		"missing field": {
+			opts: RateByOptions{Field: "tenant"},
+			data: map[string]interface{}{"user": "alice"},
+		},
+		"host": {
+			opts:     RateByOptions{Field: "url", Host: true},
+			data:     map[string]interface{}{"url": "https://api.example.com:8443/v1/users?id=1"},
+			expected: "api.example.com",
+			limited:  true,
+		},
+		"host of a value that isn't a URL": {
+			opts:     RateByOptions{Field: "url", Host: true},
+			data:     map[string]interface{}{"url": "example.com"},
+			expected: "example.com",
+			limited:  true,
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			k := newKeyedLimiter(td.opts)
+			key, limited := k.key(Record{Data: td.data})
+			assert.Equal(t, td.limited, limited)
+			assert.Equal(t, td.expected, key)
+		})
+	}
+}
+
+// each value gets its own allowance
+func TestKeyedLimiterSeparateKeys(t *testing.T) {
+	k := newKeyedLimiter(RateByOptions{Field: "tenant", RPS: 1, MaxKeys: 10})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	a := Record{Data: map[string]interface{}{"tenant": "a"}}
+	b := Record{Data: map[string]interface{}{"tenant": "b"}}
+	require.NoError(t, k.wait(ctx, a))
+	require.NoError(t, k.wait(ctx, b))
+	assert.Error(t, k.wait(ctx, a), "a second execution for a should have to wait a second")
+}
+
+func TestKeyedLimiterEviction(t *testing.T) {
+	k := newKeyedLimiter(RateByOptions{Field: "tenant", RPS: 1, MaxKeys: 2})
+	now := time.Now()
+	a := k.limiter("a", now)
+	k.limiter("b", now)
+	assert.Same(t, a, k.limiter("a", now))
+	k.limiter("c", now) // b is the least recently used
+	assert.Equal(t, 2, k.lru.Len())
+	assert.Contains(t, k.limiters, "a")
+	assert.NotContains(t, k.limiters, "b")
+	assert.Contains(t, k.limiters, "c")
+}
+
+// limiters still refilling aren't dropped, even if that means keeping more
+// than MaxKeys
+func TestKeyedLimiterEvictionKeepsBusy(t *testing.T) {
+	k := newKeyedLimiter(RateByOptions{Field: "tenant", RPS: 1, MaxKeys: 2})
+	now := time.Now()
+	k.limiter("a", now).ReserveN(now, 1)
+	k.limiter("b", now)
+	k.limiter("c", now) // a is the least recently used, but busy
+	assert.Contains(t, k.limiters, "a")
+	assert.NotContains(t, k.limiters, "b")
+
+	k.limiter("b", now).ReserveN(now, 1)
+	k.limiter("d", now)
+	assert.Equal(t, 3, k.lru.Len())
+	assert.Contains(t, k.limiters, "a")
+	assert.Contains(t, k.limiters, "b")
+	assert.NotContains(t, k.limiters, "c")
+
+	// once a has refilled it can go
+	later := now.Add(time.Second)
+	k.limiter("e", later)
+	assert.NotContains(t, k.limiters, "a")
+}
+
+// only the few least recently used limiters are looked at, so adding one
+// doesn't mean going through them all when they're busy
+func TestKeyedLimiterEvictionScan(t *testing.T) {
+	k := newKeyedLimiter(RateByOptions{Field: "tenant", RPS: 1, MaxKeys: 100})
+	now := time.Now()
+	for i := 0; i < maxEvictionScan; i++ {
+		k.take(fmt.Sprint(i), now)
+	}
+	k.limiter("idle", now)
+	k.opts.MaxKeys = 1
+	k.limiter("new", now)
+	assert.Contains(t, k.limiters, "idle")
+	assert.Equal(t, maxEvictionScan+2, k.lru.Len())
+}
+
+func TestKeyedLimiterAllowedAt(t *testing.T) {
+	k := newKeyedLimiter(RateByOptions{Field: "tenant", RPS: 2, MaxKeys: 10})
+	now := time.Now()
+	assert.Equal(t, now, k.allowedAt("a", now))
+	assert.Equal(t, now, k.allowedAt("a", now), "asking doesn't use it up")
+	k.take("a", now)
+	assert.Equal(t, now.Add(500*time.Millisecond), k.allowedAt("a", now))
+	assert.Equal(t, now.Add(500*time.Millisecond), k.allowedAt("a", now.Add(250*time.Millisecond)))
+	assert.Equal(t, now, k.allowedAt("b", now))
+}
@@ -0,0 +1,124 @@
+package streamexec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryAfterMarker starts a line a command can write to stderr to say how
+// long to wait before it's retried, e.g. because an API it called responded
+// with a Retry-After header:
+//
+//	echo "STREAM_EXEC_RETRY_AFTER=30s" >&2; exit 75
+//
+// The duration is either in seconds or Go's duration syntax. It's only acted
+// on if the command fails and the retry policy allows it to be retried, and
+// takes the place of the usual backoff delay, up to BackoffOptions.MaxDelay.
+const RetryAfterMarker = "STREAM_EXEC_RETRY_AFTER="
+
+// lines longer than this can't be a retry after hint, so aren't kept
+const maxRetryAfterLine = 256
+
+// retryAfterScanner looks for a RetryAfterMarker line in what's written to
+// it. The last one found wins.
+type retryAfterScanner struct {
+	line     []byte
+	overlong bool
+	after    time.Duration
+	found    bool
+}
+
+func (h *retryAfterScanner) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+		if len(h.line)+len(chunk) <= maxRetryAfterLine {
+			h.line = append(h.line, chunk...)
+		} else {
+			h.overlong = true
+		}
+		if i < 0 {
+			break
+		}
+		h.endLine()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Flush checks an unterminated last line
+func (h *retryAfterScanner) Flush() {
+	h.endLine()
+}
+
+func (h *retryAfterScanner) endLine() {
+	if !h.overlong {
+		if d, ok := parseRetryAfter(string(h.line)); ok {
+			h.after, h.found = d, true
+		}
+	}
+	h.line, h.overlong = h.line[:0], false
+}
+
+func parseRetryAfter(line string) (time.Duration, bool) {
+	val, ok := strings.CutPrefix(strings.TrimSpace(line), RetryAfterMarker)
+	if !ok {
+		return 0, false
+	}
+	if secs, err := strconv.ParseFloat(val, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+		return d, true
+	}
+	return 0, false
+}
+
+// retryAfterError is a failure the command asked to be retried after a
+// given delay
+type retryAfterError struct {
+	after time.Duration
+	err   error
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", e.err, e.after)
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// pause stops any command being started, by any worker, until the given
+// time. An earlier pause that runs for longer is kept.
+func (s *StreamExec) pause(until time.Time) {
+	for {
+		cur := s.pausedUntil.Load()
+		if until.UnixNano() <= cur || s.pausedUntil.CompareAndSwap(cur, until.UnixNano()) {
+			return
+		}
+	}
+}
+
+// waitWhilePaused blocks until any pause is over, or ctx is done
+func (s *StreamExec) waitWhilePaused(ctx context.Context) error {
+	for {
+		wait := time.Until(time.Unix(0, s.pausedUntil.Load()))
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
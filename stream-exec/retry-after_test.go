@@ -0,0 +1,140 @@
+package streamexec
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfterScanner(t *testing.T) {
+	tests := map[string]struct {
+		writes        []string
+		expectedFound bool
+		expectedAfter time.Duration
+	}{
+		"duration": {
+			writes:        []string{"STREAM_EXEC_RETRY_AFTER=30s\n"},
+			expectedFound: true,
+			expectedAfter: 30 * time.Second,
+		},
+		"seconds": {
+			writes:        []string{"STREAM_EXEC_RETRY_AFTER=2.5\n"},
+			expectedFound: true,
+			expectedAfter: 2500 * time.Millisecond,
+		},
+		"among other output": {
+			writes:        []string{"curl: (22) 429\nSTREAM_EXEC_RETRY_AFTER=1m\nbye\n"},
+			expectedFound: true,
+			expectedAfter: time.Minute,
+		},
+		"split across writes": {
+			writes:        []string{"STREAM_EXEC_RE", "TRY_AFTER=5", "s\n"},
+			expectedFound: true,
+			expectedAfter: 5 * time.Second,
+		},
+		"unterminated last line": {
+			writes:        []string{"STREAM_EXEC_RETRY_AFTER=5s"},
+			expectedFound: true,
+			expectedAfter: 5 * time.Second,
+		},
+		"last one wins": {
+			writes:        []string{"STREAM_EXEC_RETRY_AFTER=5s\nSTREAM_EXEC_RETRY_AFTER=7s\n"},
+			expectedFound: true,
+			expectedAfter: 7 * time.Second,
+		},
+		"invalid duration": {
+			writes: []string{"STREAM_EXEC_RETRY_AFTER=soon\n"},
+		},
+		"negative": {
+			writes: []string{"STREAM_EXEC_RETRY_AFTER=-5s\n"},
+		},
+		"not at the start of the line": {
+			writes: []string{"echo STREAM_EXEC_RETRY_AFTER=5s\n"},
+		},
+		"none": {
+			writes: []string{"hello\n"},
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			var h retryAfterScanner
+			for _, w := range td.writes {
+				h.Write([]byte(w))
+			}
+			h.Flush()
+			assert.Equal(t, td.expectedFound, h.found)
+			assert.Equal(t, td.expectedAfter, h.after)
+		})
+	}
+}
+
+// a line too long to be a hint doesn't make the scanner hold onto it
+func TestRetryAfterScannerLongLine(t *testing.T) {
+	var h retryAfterScanner
+	long := make([]byte, 100000)
+	h.Write(long)
+	h.Write([]byte("\nSTREAM_EXEC_RETRY_AFTER=1s\n"))
+	assert.LessOrEqual(t, cap(h.line), 1024)
+	assert.True(t, h.found)
+	assert.Equal(t, time.Second, h.after)
+}
+
+// a retry after hint replaces the backoff delay
+func TestExecWithRetriesRetryAfter(t *testing.T) {
+	b := newBackoff(BackoffOptions{Base: time.Hour, MaxDelay: time.Hour, Jitter: JitterNone})
+	attempts := 0
+	start := time.Now()
+	err := execWithRetries(context.Background(), 3, func(attempt int) error {
+		attempts++
+		if attempt == 1 {
+			return &retryAfterError{after: 10 * time.Millisecond, err: errors.New("rate limited")}
+		}
+		return nil
+	}, func(error) bool { return true }, func(string) {}, b)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// the hint is no longer than MaxDelay, however long the command asks for
+func TestExecWithRetriesRetryAfterCapped(t *testing.T) {
+	b := newBackoff(BackoffOptions{Base: time.Millisecond, MaxDelay: 10 * time.Millisecond, Jitter: JitterNone})
+	start := time.Now()
+	err := execWithRetries(context.Background(), 1, func(attempt int) error {
+		if attempt == 1 {
+			return &retryAfterError{after: 87600 * time.Hour, err: errors.New("rate limited")}
+		}
+		return nil
+	}, func(error) bool { return true }, func(string) {}, b)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+// a hint doesn't override the retry policy
+func TestExecWithRetriesRetryAfterNotRetryable(t *testing.T) {
+	b := newBackoff(BackoffOptions{Base: time.Millisecond, MaxDelay: time.Millisecond, Jitter: JitterNone})
+	attempts := 0
+	err := execWithRetries(context.Background(), 3, func(attempt int) error {
+		attempts++
+		return &retryAfterError{after: time.Millisecond, err: errors.New("rate limited")}
+	}, func(error) bool { return false }, func(string) {}, b)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestPause(t *testing.T) {
+	s := &StreamExec{}
+	start := time.Now()
+	s.pause(start.Add(50 * time.Millisecond))
+	s.pause(start.Add(10 * time.Millisecond)) // shorter, ignored
+	assert.NoError(t, s.waitWhilePaused(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	s.pause(time.Now().Add(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, s.waitWhilePaused(ctx))
+}
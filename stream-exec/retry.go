@@ -0,0 +1,131 @@
+package streamexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// How the delay between retries is randomised, so that commands failing at
+// the same time don't all retry at the same time too.
+const (
+	JitterFull         = "full"         // anywhere between 0 and the exponential delay
+	JitterDecorrelated = "decorrelated" // between the base and 3x the previous delay
+	JitterNone         = "none"         // exactly the exponential delay
+)
+
+const defaultRetryBase = time.Second
+const defaultRetryMaxDelay = time.Minute
+
+// BackoffOptions controls how long to wait before each retry. Delays grow
+// exponentially from Base, up to MaxDelay.
+type BackoffOptions struct {
+	Base     time.Duration // defaults to 1s
+	MaxDelay time.Duration // defaults to 1m
+	Jitter   string        // one of the Jitter constants; defaults to JitterFull
+}
+
+// RetryPolicy picks out which failures are worth retrying, when some are
+// known to be permanent. With nothing set every failure is retried.
+type RetryPolicy struct {
+	OnExit    []int          // retry only these exit codes, and timeouts...
+	OnOutput  *regexp.Regexp // ...or failures whose stdout or stderr matches this
+	NotOnExit []int          // never retry these exit codes
+}
+
+// retryable says whether the failed attempt res describes should be retried
+func (p RetryPolicy) retryable(res *Result) bool {
+	if slices.Contains(p.NotOnExit, res.ExitCode) {
+		return false
+	}
+	if len(p.OnExit) == 0 && p.OnOutput == nil {
+		return true
+	}
+	if res.TimedOut || slices.Contains(p.OnExit, res.ExitCode) {
+		return true
+	}
+	return p.OnOutput != nil && (p.OnOutput.MatchString(res.Stdout) || p.OnOutput.MatchString(res.Stderr))
+}
+
+// backoff works out the delays for one command's retries
+type backoff struct {
+	BackoffOptions
+	prev   time.Duration
+	random func(n int64) int64 // in [0, n)
+}
+
+func newBackoff(o BackoffOptions) *backoff {
+	return &backoff{BackoffOptions: o, random: rand.Int64N}
+}
+
+// next returns how long to wait before the given retry, counting from 1
+func (b *backoff) next(retry int) time.Duration {
+	var d time.Duration
+	switch b.Jitter {
+	case JitterDecorrelated:
+		prev := max(b.prev, b.Base)
+		d = b.between(b.Base, min(3*prev, b.MaxDelay))
+		b.prev = d
+	case JitterNone:
+		d = b.exponential(retry)
+	default:
+		d = b.between(0, b.exponential(retry))
+	}
+	return d
+}
+
+// exponential is Base * 2^(retry-1), capped at MaxDelay
+func (b *backoff) exponential(retry int) time.Duration {
+	d := b.Base
+	for i := 1; i < retry && d < b.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, b.MaxDelay)
+}
+
+func (b *backoff) between(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(b.random(int64(hi-lo)+1))
+}
+
+// execWithRetries calls f until it succeeds, it's been retried retries
+// times, or retryable says its failure isn't worth retrying. It waits
+// between attempts as set by backoff, or if f's error is a
+// *retryAfterError, for as long as that says, up to backoff's MaxDelay.
+// f is passed the attempt number, starting from 1. Waiting stops early if
+// ctx is done.
+func execWithRetries(ctx context.Context, retries int, f func(attempt int) error, retryable func(error) bool, debugPrintFn func(string), backoff *backoff) error {
+	var lastErr error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			delay := backoff.next(i)
+			var hint *retryAfterError
+			if errors.As(lastErr, &hint) {
+				// the command's word isn't taken for more than MaxDelay
+				delay = min(hint.after, backoff.MaxDelay)
+			}
+			debugPrintFn(fmt.Sprintf("retry attempt %d in %v", i, delay))
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return lastErr
+			}
+		}
+		lastErr = f(i + 1)
+		if lastErr == nil {
+			// we're done, complete
+			return nil
+		}
+		if i < retries && !retryable(lastErr) {
+			debugPrintFn(fmt.Sprintf("not retrying: %v", lastErr))
+			return lastErr
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,189 @@
+package streamexec
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := map[string]struct {
+		opts BackoffOptions
+		// random returns this fraction of its range
+		fraction float64
+		expected []time.Duration
+	}{
+		"no jitter doubles up to the max": {
+			opts:     BackoffOptions{Base: time.Second, MaxDelay: 10 * time.Second, Jitter: JitterNone},
+			expected: []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		"full jitter at its top": {
+			opts:     BackoffOptions{Base: time.Second, MaxDelay: 10 * time.Second, Jitter: JitterFull},
+			fraction: 1,
+			expected: []time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second},
+		},
+		"full jitter halfway": {
+			opts:     BackoffOptions{Base: time.Second, MaxDelay: 10 * time.Second, Jitter: JitterFull},
+			fraction: 0.5,
+			expected: []time.Duration{500 * time.Millisecond, 1 * time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second},
+		},
+		"full jitter at its bottom": {
+			opts:     BackoffOptions{Base: time.Second, MaxDelay: 10 * time.Second, Jitter: JitterFull},
+			fraction: 0,
+			expected: []time.Duration{0, 0, 0},
+		},
+		"decorrelated jitter at its top triples": {
+			opts:     BackoffOptions{Base: time.Second, MaxDelay: 20 * time.Second, Jitter: JitterDecorrelated},
+			fraction: 1,
+			expected: []time.Duration{3 * time.Second, 9 * time.Second, 20 * time.Second, 20 * time.Second},
+		},
+		"decorrelated jitter at its bottom stays at the base": {
+			opts:     BackoffOptions{Base: time.Second, MaxDelay: 20 * time.Second, Jitter: JitterDecorrelated},
+			fraction: 0,
+			expected: []time.Duration{time.Second, time.Second, time.Second},
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			b := newBackoff(td.opts)
+			b.random = func(n int64) int64 { return int64(float64(n-1) * td.fraction) }
+			var delays []time.Duration
+			for i := range td.expected {
+				delays = append(delays, b.next(i+1).Round(time.Millisecond))
+			}
+			assert.Equal(t, td.expected, delays)
+		})
+	}
+}
+
+func TestExecWithRetries(t *testing.T) {
+	failTimes := func(n int, attempts *[]int) func(int) error {
+		return func(attempt int) error {
+			*attempts = append(*attempts, attempt)
+			if attempt <= n {
+				return errors.New("failed")
+			}
+			return nil
+		}
+	}
+	b := newBackoff(BackoffOptions{Base: time.Millisecond, MaxDelay: time.Millisecond, Jitter: JitterNone})
+	noop := func(string) {}
+	always := func(error) bool { return true }
+
+	tests := map[string]struct {
+		retries          int
+		failures         int
+		expectedErr      bool
+		expectedAttempts []int
+	}{
+		"succeeds first time": {retries: 3, failures: 0, expectedAttempts: []int{1}},
+		"succeeds on retry":   {retries: 3, failures: 2, expectedAttempts: []int{1, 2, 3}},
+		"runs out of retries": {retries: 2, failures: 5, expectedErr: true, expectedAttempts: []int{1, 2, 3}},
+		"no retries":          {retries: 0, failures: 1, expectedErr: true, expectedAttempts: []int{1}},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			var attempts []int
+			err := execWithRetries(context.Background(), td.retries, failTimes(td.failures, &attempts), always, noop, b)
+			assert.Equal(t, td.expectedErr, err != nil)
+			assert.Equal(t, td.expectedAttempts, attempts)
+		})
+	}
+}
+
+// a cancelled run doesn't wait out the backoff
+func TestExecWithRetriesCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := newBackoff(BackoffOptions{Base: time.Hour, MaxDelay: time.Hour, Jitter: JitterNone})
+	attempts := 0
+	err := execWithRetries(ctx, 5, func(int) error {
+		attempts++
+		cancel()
+		return errors.New("failed")
+	}, func(error) bool { return true }, func(string) {}, b)
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+// a failure that isn't retryable is returned straight away
+func TestExecWithRetriesNotRetryable(t *testing.T) {
+	b := newBackoff(BackoffOptions{Base: time.Millisecond, MaxDelay: time.Millisecond, Jitter: JitterNone})
+	permanent := errors.New("permanent")
+	attempts := 0
+	err := execWithRetries(context.Background(), 5, func(attempt int) error {
+		attempts++
+		if attempt == 1 {
+			return errors.New("transient")
+		}
+		return permanent
+	}, func(err error) bool { return err != permanent }, func(string) {}, b)
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 2, attempts)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := map[string]struct {
+		policy   RetryPolicy
+		res      Result
+		expected bool
+	}{
+		"retry everything by default": {
+			res:      Result{ExitCode: 1},
+			expected: true,
+		},
+		"listed exit code": {
+			policy:   RetryPolicy{OnExit: []int{7, 28}},
+			res:      Result{ExitCode: 28},
+			expected: true,
+		},
+		"unlisted exit code": {
+			policy:   RetryPolicy{OnExit: []int{7, 28}},
+			res:      Result{ExitCode: 22},
+			expected: false,
+		},
+		"timeouts are retried with an exit code list": {
+			policy:   RetryPolicy{OnExit: []int{7}},
+			res:      Result{ExitCode: -1, TimedOut: true},
+			expected: true,
+		},
+		"output matches": {
+			policy:   RetryPolicy{OnOutput: regexp.MustCompile(`HTTP 5\d\d`)},
+			res:      Result{ExitCode: 22, Stderr: "got HTTP 503"},
+			expected: true,
+		},
+		"output doesn't match": {
+			policy:   RetryPolicy{OnOutput: regexp.MustCompile(`HTTP 5\d\d`)},
+			res:      Result{ExitCode: 22, Stdout: "got HTTP 404"},
+			expected: false,
+		},
+		"exit code or output": {
+			policy:   RetryPolicy{OnExit: []int{7}, OnOutput: regexp.MustCompile(`try again`)},
+			res:      Result{ExitCode: 1, Stdout: "please try again"},
+			expected: true,
+		},
+		"excluded exit code": {
+			policy:   RetryPolicy{NotOnExit: []int{22}},
+			res:      Result{ExitCode: 22},
+			expected: false,
+		},
+		"other exit codes retried with an exclusion": {
+			policy:   RetryPolicy{NotOnExit: []int{22}},
+			res:      Result{ExitCode: 7},
+			expected: true,
+		},
+		"exclusion wins": {
+			policy:   RetryPolicy{OnExit: []int{22}, NotOnExit: []int{22}},
+			res:      Result{ExitCode: 22},
+			expected: false,
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, td.expected, td.policy.retryable(&td.res))
+		})
+	}
+}
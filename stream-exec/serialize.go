@@ -0,0 +1,151 @@
+package streamexec
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+)
+
+const defaultMaxPendingKeys = 1000
+
+// serializer makes sure records sharing a key never run at the same time.
+// Records claim their key in input order as they're read, and records for
+// a key that's already claimed queue up behind it; when the worker running
+// a key's record finishes, it runs the next one, so each key's records run
+// one at a time in input order while other keys carry on in parallel.
+type serializer struct {
+	field        string
+	exactNumbers bool
+	maxPending   int // how many keys can have records queued
+
+	mu      sync.Mutex
+	active  map[string][]Record // keys being run, with the records queued behind them
+	pending int                 // keys in active with records queued
+	drained chan struct{}       // closed and replaced whenever a key's queue empties
+}
+
+func newSerializer(field string, maxPending int, exactNumbers bool) *serializer {
+	return &serializer{
+		field:        field,
+		exactNumbers: exactNumbers,
+		maxPending:   maxPending,
+		active:       make(map[string][]Record),
+		drained:      make(chan struct{}),
+	}
+}
+
+// key is the record's value for the field, and false if it doesn't have
+// one, in which case it isn't serialized
+func (s *serializer) key(rec Record) (string, bool) {
+	val, ok := rec.Data[s.field]
+	if !ok {
+		return "", false
+	}
+	return convert(val, s.exactNumbers), true
+}
+
+// claim returns true if the record can be run now, or false if it's been
+// queued for whichever worker is running its key. With maxPending keys
+// already queued, a record for another busy key waits for one to drain.
+// Records must be claimed in input order.
+func (s *serializer) claim(ctx context.Context, rec Record) (bool, error) {
+	key, ok := s.key(rec)
+	if !ok {
+		return true, nil
+	}
+	for {
+		s.mu.Lock()
+		queue, running := s.active[key]
+		if !running {
+			s.active[key] = nil
+			s.mu.Unlock()
+			return true, nil
+		}
+		if len(queue) > 0 || s.pending < s.maxPending {
+			if len(queue) == 0 {
+				s.pending++
+			}
+			s.active[key] = append(queue, rec)
+			s.mu.Unlock()
+			return false, nil
+		}
+		drained := s.drained
+		s.mu.Unlock()
+
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+// next is called once rec has been run, returning the next record queued
+// for its key. If there isn't one, the key is released.
+func (s *serializer) next(rec Record) (Record, bool) {
+	key, ok := s.key(rec)
+	if !ok {
+		return Record{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.active[key]
+	if len(queue) == 0 {
+		delete(s.active, key)
+		return Record{}, false
+	}
+	next := queue[0]
+	if len(queue) == 1 {
+		s.active[key] = nil
+		s.pending--
+		close(s.drained)
+		s.drained = make(chan struct{})
+	} else {
+		s.active[key] = queue[1:]
+	}
+	return next, true
+}
+
+// drain removes and returns every record still queued, in input order
+func (s *serializer) drain() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Record
+	for key, queue := range s.active {
+		out = append(out, queue...)
+		delete(s.active, key)
+	}
+	s.pending = 0
+	slices.SortFunc(out, func(a, b Record) int { return cmp.Compare(a.Index, b.Index) })
+	return out
+}
+
+// serializeRecords claims each record from in, passing on to out those
+// that can run now. With KeepOrder, records wait for the reorder window
+// here, in input order, rather than once a worker has them: a worker that
+// picks up the records queued behind a key would otherwise be stuck
+// waiting on earlier records that no worker is free to run.
+func (s *StreamExec) serializeRecords(ctx context.Context, in <-chan Record, out chan<- Record) {
+	defer s.readWG.Done()
+	defer close(out)
+	for rec := range in {
+		if s.orderer != nil {
+			if err := s.orderer.wait(ctx, rec.Index); err != nil {
+				return // context cancelled
+			}
+		}
+		run, err := s.serializer.claim(ctx, rec)
+		if err != nil {
+			return // context cancelled
+		}
+		if !run {
+			continue
+		}
+		select {
+		case out <- rec:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
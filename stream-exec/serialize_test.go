@@ -0,0 +1,143 @@
+package streamexec
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func userRecord(index int64, user interface{}) Record {
+	data := map[string]interface{}{}
+	if user != nil {
+		data["user"] = user
+	}
+	return Record{Data: data, Index: index}
+}
+
+func TestSerializerQueuesSameKey(t *testing.T) {
+	s := newSerializer("user", 10, false)
+	claim := func(rec Record) bool {
+		run, err := s.claim(context.Background(), rec)
+		require.NoError(t, err)
+		return run
+	}
+
+	first := userRecord(0, "alice")
+	assert.True(t, claim(first))
+	assert.True(t, claim(userRecord(1, "bob")))
+	assert.False(t, claim(userRecord(2, "alice")))
+	assert.False(t, claim(userRecord(3, "alice")))
+	assert.True(t, claim(userRecord(4, nil)), "records without the field aren't serialized")
+
+	// the worker that ran the first alice record runs the rest in order
+	var indices []int64
+	for rec, ok := first, true; ok; rec, ok = s.next(rec) {
+		indices = append(indices, rec.Index)
+	}
+	assert.Equal(t, []int64{0, 2, 3}, indices)
+
+	// once released, the key can be claimed again
+	assert.True(t, claim(userRecord(5, "alice")))
+}
+
+func TestSerializerNumericKeys(t *testing.T) {
+	s := newSerializer("user", 10, false)
+	run, err := s.claim(context.Background(), userRecord(0, float64(1)))
+	require.NoError(t, err)
+	assert.True(t, run)
+	run, err = s.claim(context.Background(), userRecord(1, float64(1)))
+	require.NoError(t, err)
+	assert.False(t, run)
+}
+
+// once maxPending keys have records queued, a record for another busy key
+// waits until one drains
+func TestSerializerMaxPending(t *testing.T) {
+	s := newSerializer("user", 1, false)
+	alice, bob := userRecord(0, "alice"), userRecord(1, "bob")
+	for _, rec := range []Record{alice, bob, userRecord(2, "alice")} {
+		_, err := s.claim(context.Background(), rec)
+		require.NoError(t, err)
+	}
+
+	claimed := make(chan bool)
+	go func() {
+		run, err := s.claim(context.Background(), userRecord(3, "bob"))
+		assert.NoError(t, err)
+		claimed <- run
+	}()
+	select {
+	case <-claimed:
+		t.Fatal("claim didn't wait for a pending key to drain")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	next, ok := s.next(alice)
+	require.True(t, ok)
+	assert.Equal(t, int64(2), next.Index)
+	select {
+	case run := <-claimed:
+		assert.False(t, run)
+	case <-time.After(time.Second):
+		t.Fatal("claim didn't resume once a key drained")
+	}
+}
+
+func TestSerializerCancelled(t *testing.T) {
+	s := newSerializer("user", 1, false)
+	for _, rec := range []Record{userRecord(0, "alice"), userRecord(1, "bob"), userRecord(2, "alice")} {
+		_, err := s.claim(context.Background(), rec)
+		require.NoError(t, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := s.claim(ctx, userRecord(3, "bob"))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// records still queued behind a key when the workers stop are run by drain
+func TestDrainRunsQueued(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	s := NewWithSource(nil, out, nopWriteCloser{io.Discard}, Options{
+		IncomingBufferSize: 10,
+		SerializeBy:        "k",
+		Params:             Params{ExecString: "echo $k$n"},
+	})
+	for i, kn := range []string{"a1", "a2", "b3", "a4"} {
+		rec := Record{Data: map[string]interface{}{"k": kn[:1], "n": kn[1:]}, Index: int64(i)}
+		run, err := s.serializer.claim(context.Background(), rec)
+		require.NoError(t, err)
+		if run {
+			s.incoming <- rec
+		}
+	}
+	close(s.incoming)
+	s.drain(context.Background())
+
+	b, err := os.ReadFile(out.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "a1\nb3\na2\na4\n", string(b))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestSerializerDrain(t *testing.T) {
+	s := newSerializer("user", 10, false)
+	for i, user := range []string{"a", "b", "a", "b", "a"} {
+		_, err := s.claim(context.Background(), userRecord(int64(i), user))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []int64{2, 3, 4}, batchIndices(s.drain()))
+	assert.Empty(t, s.drain())
+}
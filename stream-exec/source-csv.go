@@ -0,0 +1,93 @@
+package streamexec
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CSVOptions configures NewCSVSource.
+type CSVOptions struct {
+	Delimiter  rune // defaults to ','
+	LazyQuotes bool
+	NoHeader   bool // expose columns as c1, c2, ... and treat the first row as data
+}
+
+type csvSource struct {
+	r      *csv.Reader
+	closer io.Closer
+	opts   CSVOptions
+	header []string
+	read   bool
+}
+
+// NewCSVSource streams rows from CSV (or TSV, with a tab delimiter) input.
+// Each row is keyed by the header row, or by column position (c1, c2, ...)
+// with NoHeader set. Fields beyond the end of the header are keyed by
+// position too. The Raw bytes of each record are the row encoded as a JSON
+// object.
+func NewCSVSource(r io.ReadCloser, opts CSVOptions) Source {
+	cr := csv.NewReader(r)
+	if opts.Delimiter != 0 {
+		cr.Comma = opts.Delimiter
+	}
+	cr.LazyQuotes = opts.LazyQuotes
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	return &csvSource{
+		r:      cr,
+		closer: r,
+		opts:   opts,
+	}
+}
+
+func (s *csvSource) Next() (Record, error) {
+	if !s.read && !s.opts.NoHeader {
+		row, err := s.r.Read()
+		if err == io.EOF {
+			return Record{}, err
+		}
+		if err != nil {
+			return Record{}, fmt.Errorf("reading csv header: %w", err)
+		}
+		s.header = append(s.header, row...)
+	}
+	s.read = true
+
+	row, err := s.r.Read()
+	if err == io.EOF {
+		return Record{}, err
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		// the reader carries on from the next row
+		return Record{}, &RecordError{Line: parseErr.StartLine, Err: fmt.Errorf("parsing csv: %w", err)}
+	}
+	if err != nil {
+		return Record{}, fmt.Errorf("parsing csv: %w", err)
+	}
+	line, _ := s.r.FieldPos(0)
+
+	data := make(map[string]interface{}, len(row))
+	for i, v := range row {
+		if i < len(s.header) {
+			data[s.header[i]] = v
+		} else {
+			data[fmt.Sprintf("c%d", i+1)] = v
+		}
+	}
+	var raw bytes.Buffer
+	enc := json.NewEncoder(&raw)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(data); err != nil {
+		return Record{}, &RecordError{Line: line, Err: err}
+	}
+	return Record{Data: data, Raw: bytes.TrimSpace(raw.Bytes()), IsJSON: true, Line: line}, nil
+}
+
+func (s *csvSource) Close() error {
+	return s.closer.Close()
+}
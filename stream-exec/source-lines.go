@@ -0,0 +1,69 @@
+package streamexec
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+const defaultLineVar = "line"
+
+// LinesOptions configures NewLinesSource.
+type LinesOptions struct {
+	Var           string // variable each line is exposed as, defaults to "line"
+	NullDelimited bool   // split on NUL rather than newline, as from `find -print0`
+}
+
+type linesSource struct {
+	r      *bufio.Reader
+	closer io.Closer
+	opts   LinesOptions
+	line   int
+}
+
+// NewLinesSource exposes each line of plain text input as a single variable.
+// Lines are taken verbatim apart from a trailing carriage return, and empty
+// lines are skipped.
+func NewLinesSource(r io.ReadCloser, opts LinesOptions) Source {
+	if opts.Var == "" {
+		opts.Var = defaultLineVar
+	}
+	return &linesSource{
+		r:      bufio.NewReaderSize(r, defaultInputByteLen),
+		closer: r,
+		opts:   opts,
+	}
+}
+
+func (s *linesSource) Next() (Record, error) {
+	delim := byte('\n')
+	if s.opts.NullDelimited {
+		delim = 0
+	}
+	for {
+		line, err := s.r.ReadBytes(delim)
+		if len(line) == 0 && err != nil {
+			return Record{}, err
+		}
+		s.line++
+		line = bytes.TrimSuffix(line, []byte{delim})
+		if delim == '\n' {
+			line = bytes.TrimSuffix(line, []byte{'\r'})
+		}
+		if len(line) == 0 {
+			if err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		return Record{
+			Data: map[string]interface{}{s.opts.Var: string(line)},
+			Raw:  line,
+			Line: s.line,
+		}, nil
+	}
+}
+
+func (s *linesSource) Close() error {
+	return s.closer.Close()
+}
@@ -0,0 +1,121 @@
+package streamexec
+
+import (
+	"io"
+	"sync"
+)
+
+// NamedSource is an input that's opened only once MultiSource reaches it, so
+// a long list of files doesn't hold them all open at once.
+type NamedSource struct {
+	Name string
+	Open func() (Source, error)
+}
+
+type multiSource struct {
+	mu      sync.Mutex
+	sources []NamedSource
+	current Source
+	name    string
+	closed  bool
+}
+
+// NewMultiSource reads each of sources in turn as a single stream. Records
+// that don't already carry a Source are tagged with the name of the input
+// they came from.
+func NewMultiSource(sources []NamedSource) Source {
+	return &multiSource{sources: sources}
+}
+
+func (s *multiSource) Next() (Record, error) {
+	for {
+		src, name, err := s.currentSource()
+		if err != nil {
+			return Record{}, err
+		}
+		rec, err := src.Next()
+		if err == io.EOF {
+			s.advance()
+			continue
+		}
+		if rec.Source == "" {
+			rec.Source = name
+		}
+		if recErr, ok := err.(*RecordError); ok {
+			return rec, &RecordError{Line: recErr.Line, Raw: recErr.Raw, Err: sourceError{name, recErr.Err}}
+		}
+		if err != nil {
+			return rec, sourceError{name, err}
+		}
+		return rec, nil
+	}
+}
+
+// opens the next input if there's none open, returning io.EOF once all of
+// them have been read. Opening can block (e.g. on a followed file that's
+// still empty), so it's done without holding mu, leaving Close free to run.
+func (s *multiSource) currentSource() (Source, string, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, "", io.EOF
+	}
+	if s.current != nil {
+		defer s.mu.Unlock()
+		return s.current, s.name, nil
+	}
+	if len(s.sources) == 0 {
+		s.mu.Unlock()
+		return nil, "", io.EOF
+	}
+	next := s.sources[0]
+	s.sources = s.sources[1:]
+	s.mu.Unlock()
+
+	src, err := next.Open()
+	if err != nil {
+		return nil, "", sourceError{next.Name, err}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		// closed while it was being opened
+		src.Close()
+		return nil, "", io.EOF
+	}
+	s.current, s.name = src, next.Name
+	return src, next.Name, nil
+}
+
+func (s *multiSource) advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+}
+
+func (s *multiSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.current != nil {
+		return s.current.Close()
+	}
+	return nil
+}
+
+type sourceError struct {
+	name string
+	err  error
+}
+
+func (e sourceError) Error() string {
+	return e.name + ": " + e.err.Error()
+}
+
+func (e sourceError) Unwrap() error {
+	return e.err
+}
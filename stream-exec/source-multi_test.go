@@ -0,0 +1,65 @@
+package streamexec
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stringSource(name, content string, opened *[]string) NamedSource {
+	return NamedSource{
+		Name: name,
+		Open: func() (Source, error) {
+			*opened = append(*opened, name)
+			return NewJSONLinesSource(io.NopCloser(strings.NewReader(content))), nil
+		},
+	}
+}
+
+func TestMultiSource(t *testing.T) {
+	var opened []string
+	src := NewMultiSource([]NamedSource{
+		stringSource("a", "{\"n\":1}\n{\"n\":2}\n", &opened),
+		stringSource("empty", "", &opened),
+		stringSource("b", "bad\n{\"n\":3}\n", &opened),
+	})
+
+	rec, err := src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "a", rec.Source)
+	assert.Equal(t, 1, rec.Line)
+	// inputs are opened lazily
+	assert.Equal(t, []string{"a"}, opened)
+
+	rec, err = src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, 2, rec.Line)
+
+	_, err = src.Next()
+	var recErr *RecordError
+	require.True(t, errors.As(err, &recErr))
+	assert.Equal(t, 1, recErr.Line)
+	assert.Contains(t, err.Error(), "b: ")
+
+	rec, err = src.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "b", rec.Source)
+	assert.Equal(t, 2, rec.Line)
+
+	_, err = src.Next()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []string{"a", "empty", "b"}, opened)
+}
+
+func TestMultiSourceOpenError(t *testing.T) {
+	src := NewMultiSource([]NamedSource{{
+		Name: "missing",
+		Open: func() (Source, error) { return nil, errors.New("nope") },
+	}})
+	_, err := src.Next()
+	assert.EqualError(t, err, "missing: nope")
+}
@@ -0,0 +1,134 @@
+package streamexec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Record is a single decoded unit of input.
+type Record struct {
+	Data   map[string]interface{}
+	Raw    []byte   // the record as it appeared in the input
+	IsJSON bool     // whether Raw is the record as a JSON object, rather than e.g. a line of text
+	Line   int      // 1-based position of the record in its input
+	Source string   // name of the input the record came from, if known
+	Index  int64    // 0-based position in the overall stream, set as records are read
+	Batch  []Record // with batching, the records this one stands for
+}
+
+// Source yields decoded records for execution.
+//
+// Next returns io.EOF once the input is exhausted. A *RecordError means only
+// that record was bad: it is reported and reading carries on. Any other error
+// ends the input.
+//
+// Close may be called concurrently with Next to abort a blocked read.
+type Source interface {
+	Next() (Record, error)
+	Close() error
+}
+
+// RecordError is returned by a Source for a record that couldn't be decoded.
+type RecordError struct {
+	Line int
+	Raw  []byte
+	Err  error
+}
+
+func (e *RecordError) Error() string {
+	if e.Raw == nil {
+		return e.Err.Error() // e.g. a CSV row, whose error says where it is
+	}
+	return fmt.Sprintf("%v, original data: %q", e.Err, e.Raw)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+type jsonLinesSource struct {
+	r      *bufio.Reader
+	closer io.Closer
+	line   int
+}
+
+// NewJSONLinesSource reads newline-separated JSON objects from r. Blank lines
+// are skipped.
+func NewJSONLinesSource(r io.ReadCloser) Source {
+	return &jsonLinesSource{
+		r:      bufio.NewReaderSize(r, defaultInputByteLen),
+		closer: r,
+	}
+}
+
+func (s *jsonLinesSource) Next() (Record, error) {
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return Record{}, err
+		}
+		s.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return Record{}, err
+			}
+			continue
+		}
+		data, decodeErr := decodeRecord(line)
+		if decodeErr != nil {
+			return Record{}, &RecordError{Line: s.line, Raw: line, Err: decodeErr}
+		}
+		return Record{Data: data, Raw: line, IsJSON: true, Line: s.line}, nil
+	}
+}
+
+func (s *jsonLinesSource) Close() error {
+	return s.closer.Close()
+}
+
+type jsonArraySource struct {
+	dec     *json.Decoder
+	closer  io.Closer
+	started bool
+	index   int
+}
+
+// NewJSONArraySource streams the elements of a single top-level JSON array
+// from r without loading the whole array into memory. The Line of each
+// record is its 1-based position in the array.
+func NewJSONArraySource(r io.ReadCloser) Source {
+	return &jsonArraySource{
+		dec:    json.NewDecoder(r),
+		closer: r,
+	}
+}
+
+func (s *jsonArraySource) Next() (Record, error) {
+	if !s.started {
+		if _, err := s.dec.Token(); err != nil { // consume '['
+			return Record{}, fmt.Errorf("parsing JSON array: %w", err)
+		}
+		s.started = true
+	}
+	if !s.dec.More() {
+		return Record{}, io.EOF
+	}
+	var elem json.RawMessage
+	if err := s.dec.Decode(&elem); err != nil {
+		return Record{}, fmt.Errorf("parsing JSON array element: %w", err)
+	}
+	s.index++
+	data, err := decodeRecord(elem)
+	if err != nil {
+		return Record{}, &RecordError{Line: s.index, Raw: elem, Err: err}
+	}
+	return Record{Data: data, Raw: elem, IsJSON: true, Line: s.index}, nil
+}
+
+func (s *jsonArraySource) Close() error {
+	return s.closer.Close()
+}
@@ -0,0 +1,122 @@
+package streamexec
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, src Source) ([]Record, []error) {
+	t.Helper()
+	var recs []Record
+	var errs []error
+	for {
+		rec, err := src.Next()
+		if err == io.EOF {
+			return recs, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if _, ok := err.(*RecordError); ok {
+				continue
+			}
+			return recs, errs
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func TestJSONLinesSource(t *testing.T) {
+	input := "{\"a\":1}\n\n  {\"a\":2}  \nnot json\n{\"a\":3}"
+	recs, errs := readAll(t, NewJSONLinesSource(io.NopCloser(strings.NewReader(input))))
+
+	require.Len(t, recs, 3)
+	assert.Equal(t, []byte(`{"a":1}`), recs[0].Raw)
+	assert.Equal(t, 1, recs[0].Line)
+	assert.Equal(t, []byte(`{"a":2}`), recs[1].Raw)
+	assert.Equal(t, 3, recs[1].Line)
+	assert.Equal(t, 5, recs[2].Line)
+	assert.Equal(t, json.Number("3"), recs[2].Data["a"])
+
+	require.Len(t, errs, 1)
+	recErr, ok := errs[0].(*RecordError)
+	require.True(t, ok)
+	assert.Equal(t, 4, recErr.Line)
+	assert.Equal(t, []byte("not json"), recErr.Raw)
+}
+
+func TestJSONArraySource(t *testing.T) {
+	input := ` [{"a":"x"}, 5, {"a":"y"}]`
+	recs, errs := readAll(t, NewJSONArraySource(io.NopCloser(strings.NewReader(input))))
+
+	require.Len(t, recs, 2)
+	assert.Equal(t, "x", recs[0].Data["a"])
+	assert.Equal(t, 1, recs[0].Line)
+	assert.Equal(t, "y", recs[1].Data["a"])
+	assert.Equal(t, 3, recs[1].Line)
+
+	require.Len(t, errs, 1)
+	assert.IsType(t, &RecordError{}, errs[0])
+}
+
+func TestCSVSource(t *testing.T) {
+	input := "name,city\nalice,\"New York\"\nbob,Berlin,extra\n"
+	recs, errs := readAll(t, NewCSVSource(io.NopCloser(strings.NewReader(input)), CSVOptions{}))
+
+	require.Empty(t, errs)
+	require.Len(t, recs, 2)
+	assert.Equal(t, map[string]interface{}{"name": "alice", "city": "New York"}, recs[0].Data)
+	assert.Equal(t, 2, recs[0].Line)
+	assert.Equal(t, `{"city":"New York","name":"alice"}`, string(recs[0].Raw))
+	assert.Equal(t, map[string]interface{}{"name": "bob", "city": "Berlin", "c3": "extra"}, recs[1].Data)
+}
+
+// a malformed row is skipped, and the rows after it still read
+func TestCSVSourceBadRow(t *testing.T) {
+	input := "a,b\n1,x\"y\n2,3\n4,5\n"
+	recs, errs := readAll(t, NewCSVSource(io.NopCloser(strings.NewReader(input)), CSVOptions{}))
+
+	require.Len(t, errs, 1)
+	recErr, ok := errs[0].(*RecordError)
+	require.True(t, ok)
+	assert.Equal(t, 2, recErr.Line)
+	assert.Contains(t, recErr.Error(), `bare " in non-quoted-field`)
+	require.Len(t, recs, 2)
+	assert.Equal(t, "2", recs[0].Data["a"])
+	assert.Equal(t, "4", recs[1].Data["a"])
+}
+
+func TestCSVSourceNoHeader(t *testing.T) {
+	input := "a\tb\n"
+	recs, errs := readAll(t, NewCSVSource(io.NopCloser(strings.NewReader(input)), CSVOptions{Delimiter: '\t', NoHeader: true}))
+
+	require.Empty(t, errs)
+	require.Len(t, recs, 1)
+	assert.Equal(t, map[string]interface{}{"c1": "a", "c2": "b"}, recs[0].Data)
+}
+
+func TestLinesSource(t *testing.T) {
+	input := "first line\r\n\n  padded  \nlast"
+	recs, errs := readAll(t, NewLinesSource(io.NopCloser(strings.NewReader(input)), LinesOptions{Var: "id"}))
+
+	require.Empty(t, errs)
+	require.Len(t, recs, 3)
+	assert.Equal(t, map[string]interface{}{"id": "first line"}, recs[0].Data)
+	assert.Equal(t, "  padded  ", recs[1].Data["id"])
+	assert.Equal(t, 3, recs[1].Line)
+	assert.Equal(t, []byte("last"), recs[2].Raw)
+}
+
+func TestLinesSourceNullDelimited(t *testing.T) {
+	input := "./a file\n.txt\x00./b.txt\x00"
+	recs, errs := readAll(t, NewLinesSource(io.NopCloser(strings.NewReader(input)), LinesOptions{NullDelimited: true}))
+
+	require.Empty(t, errs)
+	require.Len(t, recs, 2)
+	assert.Equal(t, "./a file\n.txt", recs[0].Data["line"])
+	assert.Equal(t, "./b.txt", recs[1].Data["line"])
+}
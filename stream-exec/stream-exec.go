@@ -2,12 +2,14 @@ package streamexec
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -22,7 +24,7 @@ type streamgroup struct {
 }
 
 type streams struct {
-	input      io.ReadCloser
+	input      Source
 	text       streamgroup
 	structured streamgroup
 }
@@ -32,33 +34,90 @@ type StreamExec struct {
 	failed             int64
 	inFlight           int64
 	currentConcurrency int64
-
-	streams     streams
-	errors      chan error
-	incoming    chan string
-	scaleDn     chan struct{}
-	rateLimiter *rate.Limiter // nil when RPS is unlimited
-	readWG      sync.WaitGroup
-	writeWG     sync.WaitGroup
-	errWG       sync.WaitGroup
-	options     Options
-	startTime   time.Time
-	ctx         context.Context
-	cancel      context.CancelFunc
-	ipcCleanup  func()
+	targetConcurrency  int // what SetConcurrency last asked for; guarded by concurrencyMu
+	concurrencyMu      sync.Mutex
+	pausedUntil        atomic.Int64 // unix nanos; no commands are started before this
+
+	streams      streams
+	textMu       sync.Mutex // held while writing to the text streams, so output doesn't interleave
+	errors       chan error
+	incoming     chan Record
+	scaleDn      chan struct{}
+	rateLimiter  *rate.Limiter          // nil when RPS is unlimited
+	keyedLimiter *keyedLimiter          // nil unless RateBy is enabled
+	breaker      *circuitBreaker        // nil unless enabled
+	autoscaler   *concurrencyController // nil unless AutoConcurrency is enabled
+	orderer      *orderer               // nil unless KeepOrder
+	serializer   *serializer            // nil unless SerializeBy is set
+	readWG       sync.WaitGroup
+	writeWG      sync.WaitGroup
+	errWG        sync.WaitGroup
+	options      Options
+	startTime    time.Time
+	ctx          context.Context
+	cancel       context.CancelFunc
+	ipcCleanup   func()
 }
 
+// New reads JSON lines from inputstream. Use NewWithSource for any other
+// input format.
 func New(inputstream io.ReadCloser, outputstream io.WriteCloser, errStream io.WriteCloser, o Options) *StreamExec {
+	return NewWithSource(NewJSONLinesSource(inputstream), outputstream, errStream, o)
+}
+
+// NewWithSource executes a command for each record yielded by source.
+func NewWithSource(source Source, outputstream io.WriteCloser, errStream io.WriteCloser, o Options) *StreamExec {
+	if o.AutoConcurrency.Enabled {
+		if o.AutoConcurrency.Min == 0 {
+			o.AutoConcurrency.Min = defaultMinConcurrency
+		}
+		if o.AutoConcurrency.Max == 0 {
+			o.AutoConcurrency.Max = max(defaultMaxConcurrency, o.AutoConcurrency.Min)
+		}
+		if o.AutoConcurrency.Interval == 0 {
+			o.AutoConcurrency.Interval = defaultAutoscaleInterval
+		}
+		o.Concurrency = o.AutoConcurrency.Min
+	}
 	if o.Concurrency == 0 {
 		o.Concurrency = defaultConcurrency
 	}
 	if o.IncomingBufferSize == 0 {
 		o.IncomingBufferSize = defaultInputByteLen
 	}
+	if o.MetaPrefix == "" {
+		o.MetaPrefix = defaultMetaPrefix
+	}
+	if o.RunID == "" {
+		o.RunID = newRunID()
+	}
+	if o.Backoff.Base == 0 {
+		o.Backoff.Base = defaultRetryBase
+	}
+	if o.Backoff.MaxDelay == 0 {
+		o.Backoff.MaxDelay = defaultRetryMaxDelay
+	}
+	if o.Breaker.Window == 0 {
+		o.Breaker.Window = defaultBreakerWindow
+	}
+	if o.Breaker.Cooldown == 0 {
+		o.Breaker.Cooldown = defaultBreakerCooldown
+	}
+	if o.KillGrace == 0 {
+		o.KillGrace = defaultKillGrace
+	}
+	if o.Params.Shell == "" && len(o.Params.Argv) == 0 {
+		o.Params.Shell = defaultShell
+	}
 
-	incomingBuffer := make(chan string, o.IncomingBufferSize)
+	bufferSize := o.IncomingBufferSize
+	if o.RateBy.enabled() {
+		// records are handed straight to a free worker, so their value's
+		// limit holds as they run; see rateLimitRecords
+		bufferSize = 0
+	}
+	incomingBuffer := make(chan Record, bufferSize)
 	errChan := make(chan error)
-	var wg sync.WaitGroup
 
 	var outputFile io.WriteCloser
 	if o.OutputLog != "" {
@@ -69,9 +128,39 @@ func New(inputstream io.ReadCloser, outputstream io.WriteCloser, errStream io.Wr
 		outputFile = f
 	}
 
-	return &StreamExec{
+	if o.Batch.enabled() {
+		if o.Batch.Input == "" {
+			o.Batch.Input = BatchInputStdin
+		}
+		if o.Batch.Input == BatchInputStdin {
+			o.Stdin = StdinOptions{Record: true}
+		}
+	}
+	if o.RateBy.MaxKeys == 0 {
+		o.RateBy.MaxKeys = defaultMaxRateLimiters
+	}
+	if o.RateBy.MaxPending == 0 {
+		o.RateBy.MaxPending = defaultMaxRatePending
+	}
+	if o.SerializeBy != "" && o.MaxPendingKeys == 0 {
+		o.MaxPendingKeys = defaultMaxPendingKeys
+	}
+	if o.KeepOrder && o.ReorderWindow == 0 {
+		o.ReorderWindow = defaultReorderWindow
+	}
+
+	var autoscaler *concurrencyController
+	if o.AutoConcurrency.Enabled {
+		autoscaler = newConcurrencyController(o.AutoConcurrency)
+	}
+	var breaker *circuitBreaker
+	if o.Breaker.enabled() {
+		breaker = newCircuitBreaker(o.Breaker)
+	}
+
+	s := &StreamExec{
 		streams: streams{
-			input: inputstream,
+			input: source,
 			text: streamgroup{
 				output: outputstream,
 				err:    errStream,
@@ -80,20 +169,44 @@ func New(inputstream io.ReadCloser, outputstream io.WriteCloser, errStream io.Wr
 				output: outputFile,
 			},
 		},
-		readWG:   wg,
-		errors:   errChan,
-		incoming: incomingBuffer,
-		scaleDn:  make(chan struct{}, 1024),
-		options:  o,
+		errors:     errChan,
+		incoming:   incomingBuffer,
+		scaleDn:    make(chan struct{}, 1024),
+		breaker:    breaker,
+		autoscaler: autoscaler,
+		options:    o,
+	}
+	if o.KeepOrder {
+		s.orderer = newOrderer(o.ReorderWindow, s.writeResult)
 	}
+	if o.RateBy.enabled() {
+		s.keyedLimiter = newKeyedLimiter(o.RateBy)
+	}
+	if o.SerializeBy != "" {
+		s.serializer = newSerializer(o.SerializeBy, o.MaxPendingKeys, o.ExactNumbers)
+	}
+	return s
 }
 
 func (s *StreamExec) Run() error {
 	s.startTime = time.Now()
 
+	// fail before consuming any input, rather than once per record
+	if s.options.OutputDir != "" {
+		if err := os.MkdirAll(s.options.OutputDir, 0755); err != nil {
+			return fmt.Errorf("can't create output directory: %w", err)
+		}
+	}
+	if len(s.options.Params.Argv) == 0 {
+		if err := checkShell(s.options.Params.Shell); err != nil {
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	s.ctx = ctx
 	s.cancel = cancel
+	s.targetConcurrency = s.options.Concurrency
 
 	ipcCleanup, err := s.startIPCServer(cancel)
 	if err != nil {
@@ -118,8 +231,29 @@ func (s *StreamExec) Run() error {
 	}
 
 	defer s.closeAll()
+	// records pass from the input through any batching, serializing and
+	// rate limiting stages to s.incoming
+	records := s.incoming
+	if s.keyedLimiter != nil {
+		in := make(chan Record)
+		s.readWG.Add(1)
+		go s.rateLimitRecords(ctx, in, records)
+		records = in
+	}
+	if s.serializer != nil {
+		in := make(chan Record)
+		s.readWG.Add(1)
+		go s.serializeRecords(ctx, in, records)
+		records = in
+	}
+	if s.options.Batch.enabled() {
+		in := make(chan Record, s.options.Batch.Size)
+		s.readWG.Add(1)
+		go s.batchRecords(ctx, in, records)
+		records = in
+	}
 	s.readWG.Add(1)
-	go s.readInput(ctx, s.streams.input)
+	go s.readInput(ctx, s.streams.input, records)
 
 	s.errWG.Add(1)
 	go s.handleErrors()
@@ -128,6 +262,9 @@ func (s *StreamExec) Run() error {
 		s.writeWG.Add(1)
 		go s.process(ctx, i)
 	}
+	if s.autoscaler != nil {
+		go s.autoscale(ctx)
+	}
 
 	s.readWG.Wait()
 	s.writeWG.Wait()
@@ -161,101 +298,183 @@ func (s *StreamExec) process(ctx context.Context, i int) {
 			return
 		case <-s.scaleDn:
 			return
-		case line, ok := <-s.incoming:
+		case rec, ok := <-s.incoming:
 			if !ok {
 				return
 			}
-			if line == "" {
-				continue
-			}
-			envvars, err := formatEnvString(line)
-			if err != nil {
-				s.errors <- fmt.Errorf("%v, original data: %q", err, line)
-				continue
-			}
-			if s.rateLimiter != nil {
-				if err := s.rateLimiter.Wait(ctx); err != nil {
+			// with a serializer or keyed rate limit, records have already
+			// waited for the reorder window before being passed on
+			if s.orderer != nil && s.serializer == nil && s.keyedLimiter == nil {
+				if err := s.orderer.wait(ctx, rec.Index); err != nil {
 					return // context cancelled
 				}
 			}
-			atomic.AddInt64(&s.inFlight, 1)
-			resultErr := s.exec(ctx, envvars)
-			atomic.AddInt64(&s.inFlight, -1)
-			if resultErr == nil {
-				continue
-			}
-			if !resultErr.Succeeded {
-				atomic.AddInt64(&s.failed, 1)
-				s.errors <- resultErr
-			} else {
-				atomic.AddInt64(&s.processed, 1)
-			}
-			err = s.writeOutput(*resultErr)
-			if err != nil {
-				s.errors <- err
+			for {
+				if err := s.run(ctx, rec, i); err != nil {
+					return // context cancelled
+				}
+				if s.serializer == nil {
+					break
+				}
+				// run anything queued behind this record's key
+				if rec, ok = s.serializer.next(rec); !ok {
+					break
+				}
+				// these didn't pass through rateLimitRecords, but this
+				// worker has to run them in turn anyway
+				if s.keyedLimiter != nil {
+					if err := s.keyedLimiter.wait(ctx, rec); err != nil {
+						return // context cancelled
+					}
+				}
 			}
 		}
 	}
 }
 
+// run waits for the circuit breaker and rate limiter, then runs the
+// command for rec
+func (s *StreamExec) run(ctx context.Context, rec Record, worker int) error {
+	var probe bool
+	if s.breaker != nil {
+		var err error
+		if probe, err = s.breaker.acquire(ctx); err != nil {
+			return err
+		}
+	}
+	if s.rateLimiter != nil {
+		if err := s.rateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	succeeded := s.execute(ctx, rec, worker)
+	if s.breaker != nil {
+		s.breakerChanged(s.breaker.done(probe, succeeded))
+	}
+	return nil
+}
+
+// runs the command for a single record and reports the result, returning
+// whether it succeeded
+func (s *StreamExec) execute(ctx context.Context, rec Record, worker int) bool {
+	atomic.AddInt64(&s.inFlight, 1)
+	start := time.Now()
+	resultErr := s.exec(ctx, rec, worker)
+	atomic.AddInt64(&s.inFlight, -1)
+	if s.autoscaler != nil {
+		s.autoscaler.observe(observedLatency(resultErr, time.Since(start)), resultErr == nil || resultErr.Succeeded)
+	}
+	if resultErr == nil {
+		s.output(rec.Index, nil)
+		return true
+	}
+	if !resultErr.Succeeded {
+		atomic.AddInt64(&s.failed, 1)
+		s.errors <- resultErr
+	} else {
+		atomic.AddInt64(&s.processed, 1)
+	}
+	s.output(rec.Index, resultErr)
+	return resultErr.Succeeded
+}
+
+// output writes the result for the record at index, which is nil if there's
+// nothing to write. With KeepOrder that waits until everything before it
+// has been written.
+func (s *StreamExec) output(index int64, res *Result) {
+	if s.orderer != nil {
+		s.orderer.done(index, res)
+		return
+	}
+	if res != nil {
+		s.writeResult(res)
+	}
+}
+
+func (s *StreamExec) writeResult(res *Result) {
+	if err := s.writeOutput(*res); err != nil {
+		s.errors <- err
+	}
+}
+
+// breakerChanged reports the circuit breaker opening or closing
+func (s *StreamExec) breakerChanged(state string) {
+	var msg string
+	switch state {
+	case BreakerOpen:
+		msg = fmt.Sprintf("circuit breaker open: too many failures, pausing for %v", s.options.Breaker.Cooldown)
+	case BreakerClosed:
+		msg = "circuit breaker closed: resuming"
+	default:
+		return
+	}
+	s.textMu.Lock()
+	defer s.textMu.Unlock()
+	fmt.Fprintln(s.streams.text.err, msg)
+}
+
 // SetConcurrency adjusts the number of active worker goroutines.
 // Safe to call from any goroutine while Run() is executing.
 func (s *StreamExec) SetConcurrency(n int) {
 	if n <= 0 || s.ctx == nil || s.ctx.Err() != nil {
 		return
 	}
-	current := int(atomic.LoadInt64(&s.currentConcurrency))
-	if n > current {
-		for i := 0; i < n-current; i++ {
+	s.concurrencyMu.Lock()
+	defer s.concurrencyMu.Unlock()
+	// work from the last target rather than the number of workers running,
+	// as workers asked to stop only do so once they've finished their
+	// current command
+	current := s.targetConcurrency
+	s.targetConcurrency = n
+	if s.autoscaler != nil {
+		s.autoscaler.setTarget(n)
+	}
+	for i := current; i < n; i++ {
+		select {
+		case <-s.scaleDn:
+			// cancels a scale down that hasn't happened yet
+		default:
 			s.writeWG.Add(1)
-			go s.process(s.ctx, current+i)
-		}
-	} else if n < current {
-		for i := 0; i < current-n; i++ {
-			s.scaleDn <- struct{}{}
+			go s.process(s.ctx, i)
 		}
 	}
+	for i := n; i < current; i++ {
+		s.scaleDn <- struct{}{}
+	}
 }
 
 func (s *StreamExec) drain(ctx context.Context) {
-	for i := 0; i < len(s.incoming); i++ {
+	// by now the input's been read and s.incoming closed
+	for rec := range s.incoming {
 		if ctx.Err() != nil {
 			break
 		}
-		line := <-s.incoming
-		if line == "" {
-			continue
-		}
-		envvars, err := formatEnvString(line)
-		if err != nil {
-			s.errors <- err
-			continue
-		}
-		atomic.AddInt64(&s.inFlight, 1)
-		resultErr := s.exec(ctx, envvars)
-		atomic.AddInt64(&s.inFlight, -1)
-		if resultErr == nil {
-			continue
-		}
-		if !resultErr.Succeeded {
-			atomic.AddInt64(&s.failed, 1)
-			s.errors <- resultErr
-		} else {
-			atomic.AddInt64(&s.processed, 1)
-		}
-		err = s.writeOutput(*resultErr)
-		if err != nil {
-			s.errors <- err
+		s.execute(ctx, rec, 0)
+	}
+	if s.serializer != nil {
+		for _, rec := range s.serializer.drain() {
+			if ctx.Err() != nil {
+				break
+			}
+			s.execute(ctx, rec, 0)
 		}
 	}
+	if s.orderer != nil {
+		s.orderer.flush()
+	}
 	close(s.errors)
 }
 
 func (s *StreamExec) writeOutput(res Result) error {
 	if s.streams.structured.output != nil {
-		s.streams.structured.output.Write([]byte(fmt.Sprintf("%v\n", res.Structured())))
+		s.streams.structured.output.Write([]byte(fmt.Sprintf("%v\n", res.withoutSpilledOutput().Structured())))
 	}
+	s.textMu.Lock()
+	defer s.textMu.Unlock()
 	if res.Succeeded {
+		if s.options.StreamOutput {
+			return nil // already written as it was produced
+		}
 		s.streams.text.output.Write([]byte(fmt.Sprintf("%v", res.Text(s.options.DebugMode))))
 	} else {
 		// write to sterr
@@ -278,6 +497,10 @@ func (s *StreamExec) handleErrors() {
 			s.errWG.Done()
 			break // closing & cleaning up
 		}
+		// failed results have already been reported by writeOutput
+		if _, ok := err.(*Result); !ok {
+			s.streams.text.err.Write([]byte(fmt.Sprintf("%v\n", err)))
+		}
 		if !s.options.ContinueOnErr {
 			s.closeAll()
 			os.Exit(1)
@@ -302,85 +525,53 @@ func (s *StreamExec) closeAll() {
 }
 
 // streams the input
-func (s *StreamExec) readInput(ctx context.Context, inputStream io.ReadCloser) error {
+func (s *StreamExec) readInput(ctx context.Context, source Source, out chan<- Record) error {
 	var closeOnce sync.Once
-	closeStream := func() { closeOnce.Do(func() { inputStream.Close() }) }
+	closeSource := func() { closeOnce.Do(func() { source.Close() }) }
 
 	// Unblock a pending Read when the context is cancelled (e.g. --stop).
 	go func() {
 		<-ctx.Done()
-		closeStream()
+		closeSource()
 	}()
-	defer closeStream()
+	defer closeSource()
 
-	// send forwards a line to the incoming channel but returns false and
-	// aborts if the context is cancelled while the channel is full.
-	send := func(line string) bool {
-		select {
-		case s.incoming <- line:
-			return true
-		case <-ctx.Done():
-			return false
-		}
-	}
-
-	var d = make([]byte, defaultInputByteLen)
-	var remainder string
-loop:
+	var index int64
 	for {
-		n, err := inputStream.Read(d)
+		rec, err := source.Next()
 		if err == io.EOF {
-			if remainder != "" {
-				for _, line := range strings.Split(remainder, "\n") {
-					if !send(line) {
-						break loop
-					}
-				}
-			}
 			break
 		}
 		if err != nil {
 			if ctx.Err() != nil {
 				break // clean stop — context was cancelled
 			}
-			panic(err)
-		}
-
-		data, newRemainder := splitInputBytes(remainder, d[:n])
-		remainder = newRemainder
-		for _, line := range data {
-			if !send(line) {
-				break loop
+			s.errors <- err
+			var recErr *RecordError
+			if errors.As(err, &recErr) {
+				continue
 			}
+			break
+		}
+		rec.Index = index
+		index++
+		select {
+		case out <- rec:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
-	close(s.incoming)
+	close(out)
 	s.readWG.Done()
 	return nil
 }
 
-// finds the last newline and separates it out since we can't
-// use a half-written line
-func splitInputBytes(prevRemainder string, data []byte) ([]string, string) {
-	dataString := string(data)
-	cleanBlockIdx := strings.LastIndex(dataString, "\n")
-	if cleanBlockIdx < 0 {
-		return nil, prevRemainder + dataString
-	}
-	// a clean block is a block of text which
-	// finishes with newline, it may or may not
-	// start partway thorough an existing line
-	cleanBlock := dataString[0:cleanBlockIdx]
-
-	nextRemainder := dataString[cleanBlockIdx:]
-	out := strings.Split(prevRemainder+cleanBlock, "\n")
-
-	// this will have a leading newline, so remove it
-	nextRemainder = strings.Replace(nextRemainder, "\n", "", 1)
-
-	// remove whitespace on lines while are finished
-	for i := range out {
-		out[i] = strings.TrimSpace(out[i])
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
 	}
-	return out, nextRemainder
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,86 @@
+package streamexec
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// maxStreamLineBytes is as much of a line as lineWriter holds back waiting
+// for its newline
+const maxStreamLineBytes = 64 * 1024
+
+// lineWriter forwards each complete line written to it to out with prefix
+// in front, as a single write made while holding mu. Commands running
+// concurrently share mu, so their lines can't interleave part way through.
+// A trailing partial line is held back until Flush, or until it reaches
+// maxLine bytes, when it's written out as a line of its own so that output
+// without newlines doesn't pile up in memory.
+type lineWriter struct {
+	mu      *sync.Mutex
+	out     io.Writer
+	prefix  []byte
+	buf     []byte
+	maxLine int
+}
+
+func newLineWriter(mu *sync.Mutex, out io.Writer, prefix string) *lineWriter {
+	return &lineWriter{mu: mu, out: out, prefix: []byte(prefix), maxLine: maxStreamLineBytes}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	rest := w.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(rest[:i+1]); err != nil {
+			return 0, err
+		}
+		rest = rest[i+1:]
+	}
+	for len(rest) >= w.maxLine {
+		if err := w.writeLine(append(rest[:w.maxLine:w.maxLine], '\n')); err != nil {
+			return 0, err
+		}
+		rest = rest[w.maxLine:]
+	}
+	w.buf = append(w.buf[:0], rest...)
+	return len(p), nil
+}
+
+// Flush writes out any unterminated last line, adding the newline
+func (w *lineWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.writeLine(append(w.buf, '\n'))
+	w.buf = w.buf[:0]
+	return err
+}
+
+func (w *lineWriter) writeLine(line []byte) error {
+	out := make([]byte, 0, len(w.prefix)+len(line))
+	out = append(append(out, w.prefix...), line...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.out.Write(out)
+	return err
+}
+
+// expandPrefix substitutes $var and ${var} in the --stream-prefix template
+// with the command's variables. Unknown variables expand to nothing, as in
+// the shell.
+func expandPrefix(template string, envvars []string) string {
+	vars := make(map[string]string, len(envvars))
+	for _, kv := range envvars {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			vars[k] = v
+		}
+	}
+	return os.Expand(template, func(k string) string { return vars[k] })
+}
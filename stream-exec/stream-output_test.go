@@ -0,0 +1,110 @@
+package streamexec
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineWriter(t *testing.T) {
+	tests := map[string]struct {
+		writes   []string
+		expected string
+	}{
+		"whole lines": {
+			writes:   []string{"a\n", "b\n"},
+			expected: "[1] a\n[1] b\n",
+		},
+		"several lines in one write": {
+			writes:   []string{"a\nb\n"},
+			expected: "[1] a\n[1] b\n",
+		},
+		"line split across writes": {
+			writes:   []string{"hel", "lo\nwor", "ld\n"},
+			expected: "[1] hello\n[1] world\n",
+		},
+		"unterminated last line is flushed with a newline": {
+			writes:   []string{"a\nb"},
+			expected: "[1] a\n[1] b\n",
+		},
+		"empty lines are kept": {
+			writes:   []string{"\n\n"},
+			expected: "[1] \n[1] \n",
+		},
+		"nothing written": {
+			expected: "",
+		},
+	}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := newLineWriter(&sync.Mutex{}, &out, "[1] ")
+			for _, s := range td.writes {
+				n, err := w.Write([]byte(s))
+				assert.NoError(t, err)
+				assert.Equal(t, len(s), n)
+			}
+			assert.NoError(t, w.Flush())
+			assert.Equal(t, td.expected, out.String())
+		})
+	}
+}
+
+// a long line is written out in pieces rather than held in memory
+func TestLineWriterLongLine(t *testing.T) {
+	var out bytes.Buffer
+	w := newLineWriter(&sync.Mutex{}, &out, "> ")
+	w.maxLine = 4
+	for _, s := range []string{"abc", "defghij", "k\nlmnopq"} {
+		_, err := w.Write([]byte(s))
+		assert.NoError(t, err)
+		assert.Less(t, len(w.buf), w.maxLine)
+	}
+	assert.NoError(t, w.Flush())
+	assert.Equal(t, "> abcd\n> efgh\n> ijk\n> lmno\n> pq\n", out.String())
+}
+
+// lines from writers sharing a mutex come out whole
+func TestLineWriterConcurrent(t *testing.T) {
+	var out bytes.Buffer
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, prefix := range []string{"a: ", "b: "} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newLineWriter(&mu, &out, prefix)
+			for i := 0; i < 1000; i++ {
+				w.Write([]byte("012345"))
+				w.Write([]byte("6789\n"))
+			}
+		}()
+	}
+	wg.Wait()
+	lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+	assert.Len(t, lines, 2000)
+	for _, l := range lines {
+		assert.Regexp(t, `^[ab]: 0123456789$`, string(l))
+	}
+}
+
+func TestExpandPrefix(t *testing.T) {
+	tests := map[string]struct {
+		template string
+		expected string
+	}{
+		"metadata":           {template: "[$SE_INDEX] ", expected: "[3] "},
+		"record field":       {template: "${city}: ", expected: "New York: "},
+		"unknown is empty":   {template: "[$nope] ", expected: "[] "},
+		"no variables":       {template: "> ", expected: "> "},
+		"value containing =": {template: "$q ", expected: "a=b "},
+	}
+	envvars := []string{"city=New York", "q=a=b", "SE_INDEX=3"}
+	for name, td := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, td.expected, expandPrefix(td.template, envvars))
+		})
+	}
+}
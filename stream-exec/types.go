@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 )
 
 const darkGray = "\033[1;30m"
@@ -12,13 +13,40 @@ const nc = "\033[0m"
 
 const redCross = "\u274c"
 
+// What's printed for each successful command in text output. Stdout and
+// stderr are always kept separately in the structured output log.
+const (
+	TextOutputInterleaved = "interleaved" // stdout and stderr in the order they were written
+	TextOutputStdout      = "stdout"      // stdout only
+)
+
 type Result struct {
-	Envvars   []string `json:",omitempty"`
-	Params    Params   `json:",omitempty"`
-	Stderr    string   `json:",omitempty"`
-	Stdout    string   `json:",omitempty"`
-	ExitCode  int      `json:",omitempty"`
-	Succeeded bool
+	Envvars         []string `json:",omitempty"`
+	Params          Params   `json:",omitempty"`
+	SourceFile      string   `json:",omitempty"`
+	Line            int      `json:",omitempty"`
+	Index           int64
+	Batch           []int64       `json:",omitempty"` // with batching, the Index of each record in the batch; Index then counts batches
+	Stderr          string        `json:",omitempty"`
+	Stdout          string        `json:",omitempty"`
+	Output          string        `json:"-"`          // Stdout and Stderr interleaved as they were written, for text output
+	StdoutTruncated int64         `json:",omitempty"` // bytes of stdout left out of Stdout by MaxOutputBytes
+	StderrTruncated int64         `json:",omitempty"`
+	StdoutFile      string        `json:",omitempty"` // where the full stdout was written, with OutputDir
+	StderrFile      string        `json:",omitempty"`
+	ExitCode        int           `json:",omitempty"`
+	TimedOut        bool          `json:",omitempty"` // killed for running longer than Params.Timeout
+	Duration        time.Duration // of all attempts, including the delays between them
+	Attempts        []Attempt     `json:",omitempty"`
+	Succeeded       bool
+}
+
+// Attempt describes one try at running a command
+type Attempt struct {
+	ExitCode   int
+	Duration   time.Duration
+	TimedOut   bool          `json:",omitempty"`
+	RetryAfter time.Duration `json:",omitempty"` // the delay the command asked for before it's retried
 }
 
 func (r Result) Text(debug bool) string {
@@ -30,9 +58,10 @@ func (r Result) Text(debug bool) string {
 		out += fmt.Sprintf("with params: %v\n", r.Params)
 	}
 	if r.Succeeded {
-		out += fmt.Sprintf("%v\n", stdout)
-		if stderr != "" {
-			out += fmt.Sprintf("%s%s%s\n", red, stderr, nc)
+		if r.Output != "" {
+			out += fmt.Sprintf("%v\n", strings.TrimRight(r.Output, "\n"))
+		} else {
+			out += fmt.Sprintf("%v\n", stdout)
 		}
 	} else {
 		out += fmt.Sprintf("%s - %s\n", redCross, stdout)
@@ -41,9 +70,25 @@ func (r Result) Text(debug bool) string {
 		}
 		out += fmt.Sprintf("%sexit code: %d%s\n", darkGray, r.ExitCode, nc)
 	}
+	if r.StdoutTruncated > 0 || r.StderrTruncated > 0 {
+		out += fmt.Sprintf("%s[output truncated: %d bytes of stdout and %d bytes of stderr not shown]%s\n",
+			darkGray, r.StdoutTruncated, r.StderrTruncated, nc)
+	}
 	return out
 }
 
+// withoutSpilledOutput leaves out output that was written to files, for
+// the output log
+func (r Result) withoutSpilledOutput() Result {
+	if r.StdoutFile != "" {
+		r.Stdout, r.StdoutTruncated = "", 0
+	}
+	if r.StderrFile != "" {
+		r.Stderr, r.StderrTruncated = "", 0
+	}
+	return r
+}
+
 func (r Result) Structured() string {
 	d, _ := json.Marshal((r))
 	return string(d)